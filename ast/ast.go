@@ -2,6 +2,8 @@ package ast
 
 import (
     "bytes"
+    "math/big"
+    "reflect"
     "strings"
     "github.com/RavenStorm-bit/toy-compiler/token"
 )
@@ -9,6 +11,8 @@ import (
 type Node interface {
     TokenLiteral() string
     String() string
+    Pos() token.Position // position of the node's first character
+    End() token.Position // position just past the node's last character
 }
 
 type Statement interface {
@@ -24,11 +28,25 @@ type Expression interface {
 type IntegerLiteral struct {
     Token token.Token
     Value int64
+    Big   *big.Int // set instead of Value when the literal does not fit in 64 bits
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Span.Start }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.Span.End }
+
+type FloatLiteral struct {
+    Token token.Token
+    Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Span.Start }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.Span.End }
 
 type InfixExpression struct {
     Token    token.Token // The operator token, e.g. +
@@ -49,9 +67,35 @@ func (ie *InfixExpression) String() string {
     return out.String()
 }
 
+func (ie *InfixExpression) Pos() token.Position { return startOf(ie.Left, ie.Token) }
+func (ie *InfixExpression) End() token.Position { return endOf(ie.Right, ie.Token) }
+
+// PrefixExpression represents a unary operator applied to its operand,
+// e.g. -x or !done
+type PrefixExpression struct {
+    Token    token.Token // The operator token, e.g. !
+    Operator string
+    Right    Expression
+}
+
+func (pe *PrefixExpression) expressionNode()      {}
+func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) String() string {
+    var out bytes.Buffer
+    out.WriteString("(")
+    out.WriteString(pe.Operator)
+    out.WriteString(pe.Right.String())
+    out.WriteString(")")
+    return out.String()
+}
+
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Span.Start }
+func (pe *PrefixExpression) End() token.Position { return endOf(pe.Right, pe.Token) }
+
 // Program is the root node of every AST
 type Program struct {
     Statements []Statement
+    Comments   []token.Token // comments in source order, for the formatter
 }
 
 func (p *Program) TokenLiteral() string {
@@ -69,6 +113,20 @@ func (p *Program) String() string {
     return out.String()
 }
 
+func (p *Program) Pos() token.Position {
+    if len(p.Statements) > 0 {
+        return p.Statements[0].Pos()
+    }
+    return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+    if len(p.Statements) > 0 {
+        return p.Statements[len(p.Statements)-1].End()
+    }
+    return token.Position{}
+}
+
 // ExpressionStatement wraps expressions that can stand alone as statements
 type ExpressionStatement struct {
     Token      token.Token
@@ -84,6 +142,9 @@ func (es *ExpressionStatement) String() string {
     return ""
 }
 
+func (es *ExpressionStatement) Pos() token.Position { return es.Token.Span.Start }
+func (es *ExpressionStatement) End() token.Position { return endOf(es.Expression, es.Token) }
+
 // Identifier represents variable names
 type Identifier struct {
     Token token.Token
@@ -93,12 +154,15 @@ type Identifier struct {
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) Pos() token.Position  { return i.Token.Span.Start }
+func (i *Identifier) End() token.Position  { return i.Token.Span.End }
 
 // LetStatement represents variable declaration
 type LetStatement struct {
     Token token.Token
     Name  *Identifier
     Value Expression
+    Doc   string // text of the /// comments directly above, if any
 }
 
 func (ls *LetStatement) statementNode()       {}
@@ -115,6 +179,14 @@ func (ls *LetStatement) String() string {
     return out.String()
 }
 
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Span.Start }
+func (ls *LetStatement) End() token.Position {
+    if !isNil(ls.Value) {
+        return ls.Value.End()
+    }
+    return endOf(ls.Name, ls.Token)
+}
+
 // StringLiteral represents string values
 type StringLiteral struct {
     Token token.Token
@@ -124,6 +196,8 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Span.Start }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.Span.End }
 
 // Boolean represents true/false values
 type Boolean struct {
@@ -134,6 +208,8 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Span.Start }
+func (b *Boolean) End() token.Position  { return b.Token.Span.End }
 
 // IfExpression represents if/else conditionals
 type IfExpression struct {
@@ -158,10 +234,28 @@ func (ie *IfExpression) String() string {
     return out.String()
 }
 
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Span.Start }
+func (ie *IfExpression) End() token.Position {
+    if ie.Alternative != nil {
+        return ie.Alternative.End()
+    }
+    if ie.Consequence != nil {
+        return ie.Consequence.End()
+    }
+    return endOf(ie.Condition, ie.Token)
+}
+
 // BlockStatement represents a block of statements
 type BlockStatement struct {
-    Token      token.Token
+    Token      token.Token // the { token, or the if of an else-if
     Statements []Statement
+    Rbrace     token.Token // the closing } token; empty for an else-if
+}
+
+// IsElseIf reports whether the block stands for the nested if of an
+// else-if rather than a braced block
+func (bs *BlockStatement) IsElseIf() bool {
+    return bs.Token.Type == token.IF
 }
 
 func (bs *BlockStatement) statementNode()       {}
@@ -174,11 +268,23 @@ func (bs *BlockStatement) String() string {
     return out.String()
 }
 
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Span.Start }
+func (bs *BlockStatement) End() token.Position {
+    if bs.Rbrace.Type != "" {
+        return bs.Rbrace.Span.End
+    }
+    if len(bs.Statements) > 0 {
+        return bs.Statements[len(bs.Statements)-1].End()
+    }
+    return bs.Token.Span.End
+}
+
 // FunctionLiteral represents function definitions
 type FunctionLiteral struct {
     Token      token.Token
     Parameters []*Identifier
     Body       *BlockStatement
+    Name       string // the let binding the literal is assigned to, if any
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -197,6 +303,14 @@ func (fl *FunctionLiteral) String() string {
     return out.String()
 }
 
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Span.Start }
+func (fl *FunctionLiteral) End() token.Position {
+    if fl.Body != nil {
+        return fl.Body.End()
+    }
+    return fl.Token.Span.End
+}
+
 // WhileStatement represents while loops
 type WhileStatement struct {
     Token     token.Token
@@ -215,6 +329,111 @@ func (ws *WhileStatement) String() string {
     return out.String()
 }
 
+func (ws *WhileStatement) Pos() token.Position { return ws.Token.Span.Start }
+func (ws *WhileStatement) End() token.Position {
+    if ws.Body != nil {
+        return ws.Body.End()
+    }
+    return endOf(ws.Condition, ws.Token)
+}
+
+// ForStatement represents C-style for loops. Init, Condition and Post
+// are each optional; a missing condition loops until a break.
+type ForStatement struct {
+    Token     token.Token
+    Init      Statement
+    Condition Expression
+    Post      Statement
+    Body      *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) String() string {
+    var out bytes.Buffer
+    out.WriteString("for (")
+    if fs.Init != nil {
+        out.WriteString(strings.TrimSuffix(fs.Init.String(), ";"))
+    }
+    out.WriteString("; ")
+    if fs.Condition != nil {
+        out.WriteString(fs.Condition.String())
+    }
+    out.WriteString("; ")
+    if fs.Post != nil {
+        out.WriteString(strings.TrimSuffix(fs.Post.String(), ";"))
+    }
+    out.WriteString(") ")
+    out.WriteString(fs.Body.String())
+    return out.String()
+}
+
+func (fs *ForStatement) Pos() token.Position { return fs.Token.Span.Start }
+func (fs *ForStatement) End() token.Position {
+    if fs.Body != nil {
+        return fs.Body.End()
+    }
+    return fs.Token.Span.End
+}
+
+// ForInStatement represents loops over the elements of an array or the
+// entries of a hash. With two variables they take an array's index and
+// element, or a hash's key and value; a single variable takes an array's
+// element or a hash's key.
+type ForInStatement struct {
+    Token     token.Token
+    Variables []*Identifier
+    Iterable  Expression
+    Body      *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) String() string {
+    var out bytes.Buffer
+    names := []string{}
+    for _, v := range fs.Variables {
+        names = append(names, v.String())
+    }
+    out.WriteString("for ")
+    out.WriteString(strings.Join(names, ", "))
+    out.WriteString(" in ")
+    out.WriteString(fs.Iterable.String())
+    out.WriteString(" ")
+    out.WriteString(fs.Body.String())
+    return out.String()
+}
+
+func (fs *ForInStatement) Pos() token.Position { return fs.Token.Span.Start }
+func (fs *ForInStatement) End() token.Position {
+    if fs.Body != nil {
+        return fs.Body.End()
+    }
+    return endOf(fs.Iterable, fs.Token)
+}
+
+// BreakStatement leaves the innermost loop
+type BreakStatement struct {
+    Token token.Token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) String() string       { return "break;" }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Span.Start }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.Span.End }
+
+// ContinueStatement skips to the next iteration of the innermost loop
+type ContinueStatement struct {
+    Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) String() string       { return "continue;" }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Span.Start }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.Span.End }
+
 // ReturnStatement represents return statements
 type ReturnStatement struct {
     Token       token.Token
@@ -233,11 +452,15 @@ func (rs *ReturnStatement) String() string {
     return out.String()
 }
 
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Span.Start }
+func (rs *ReturnStatement) End() token.Position { return endOf(rs.ReturnValue, rs.Token) }
+
 // CallExpression represents function calls
 type CallExpression struct {
     Token     token.Token // The '(' token
     Function  Expression  // Identifier or FunctionLiteral
     Arguments []Expression
+    Rparen    token.Token // the closing ) token
 }
 
 func (ce *CallExpression) expressionNode()      {}
@@ -258,11 +481,27 @@ func (ce *CallExpression) String() string {
     return out.String()
 }
 
-// AssignmentStatement represents variable assignments
+func (ce *CallExpression) Pos() token.Position { return startOf(ce.Function, ce.Token) }
+func (ce *CallExpression) End() token.Position {
+    if ce.Rparen.Type != "" {
+        return ce.Rparen.Span.End
+    }
+    if len(ce.Arguments) > 0 {
+        return endOf(ce.Arguments[len(ce.Arguments)-1], ce.Token)
+    }
+    return ce.Token.Span.End
+}
+
+// AssignmentStatement represents an assignment to a variable, an element
+// a[i] or a member a.b. Operator is "=", a compound operator such as
+// "+=", or "++" or "--", which have no Value and may come before the
+// target.
 type AssignmentStatement struct {
-    Token token.Token // the = token
-    Name  *Identifier
-    Value Expression
+    Token    token.Token // the operator token
+    Target   Expression  // an *Identifier, *IndexExpression or *MemberExpression
+    Operator string
+    Value    Expression
+    Prefix   bool // ++x or --x
 }
 
 func (as *AssignmentStatement) statementNode()       {}
@@ -270,10 +509,176 @@ func (as *AssignmentStatement) TokenLiteral() string { return as.Token.Literal }
 func (as *AssignmentStatement) String() string {
     var out bytes.Buffer
 
-    out.WriteString(as.Name.String())
-    out.WriteString(" = ")
-    out.WriteString(as.Value.String())
+    switch {
+    case as.Prefix:
+        out.WriteString(as.Operator)
+        out.WriteString(as.Target.String())
+    case as.Value == nil:
+        out.WriteString(as.Target.String())
+        out.WriteString(as.Operator)
+    default:
+        out.WriteString(as.Target.String())
+        out.WriteString(" " + as.Operator + " ")
+        out.WriteString(as.Value.String())
+    }
     out.WriteString(";")
 
     return out.String()
-}
\ No newline at end of file
+}
+
+// BinaryOperator returns the operator a compound assignment or increment
+// applies to the target's value, such as "+" for "+=" and "++", or ""
+// for a plain assignment
+func (as *AssignmentStatement) BinaryOperator() string {
+    switch as.Operator {
+    case "++":
+        return "+"
+    case "--":
+        return "-"
+    }
+    return strings.TrimSuffix(as.Operator, "=")
+}
+
+func (as *AssignmentStatement) Pos() token.Position {
+    if as.Prefix {
+        return as.Token.Span.Start
+    }
+    return startOf(as.Target, as.Token)
+}
+
+func (as *AssignmentStatement) End() token.Position {
+    if as.Prefix {
+        return endOf(as.Target, as.Token)
+    }
+    return endOf(as.Value, as.Token)
+}
+
+// ArrayLiteral represents an array literal such as [1, 2, 3]
+type ArrayLiteral struct {
+    Token    token.Token // the '[' token
+    Elements []Expression
+    Rbracket token.Token // the closing ] token
+}
+
+func (al *ArrayLiteral) expressionNode()      {}
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) String() string {
+    elements := []string{}
+    for _, e := range al.Elements {
+        elements = append(elements, e.String())
+    }
+    return "[" + strings.Join(elements, ", ") + "]"
+}
+
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Span.Start }
+func (al *ArrayLiteral) End() token.Position {
+    if al.Rbracket.Type != "" {
+        return al.Rbracket.Span.End
+    }
+    if len(al.Elements) > 0 {
+        return endOf(al.Elements[len(al.Elements)-1], al.Token)
+    }
+    return al.Token.Span.End
+}
+
+// IndexExpression represents indexing such as a[i]
+type IndexExpression struct {
+    Token    token.Token // the '[' token
+    Left     Expression
+    Index    Expression
+    Rbracket token.Token // the closing ] token
+}
+
+func (ie *IndexExpression) expressionNode()      {}
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) String() string {
+    var out bytes.Buffer
+
+    out.WriteString("(")
+    out.WriteString(ie.Left.String())
+    out.WriteString("[")
+    out.WriteString(ie.Index.String())
+    out.WriteString("])")
+
+    return out.String()
+}
+
+func (ie *IndexExpression) Pos() token.Position { return startOf(ie.Left, ie.Token) }
+func (ie *IndexExpression) End() token.Position {
+    if ie.Rbracket.Type != "" {
+        return ie.Rbracket.Span.End
+    }
+    return endOf(ie.Index, ie.Token)
+}
+
+// HashLiteral represents a hash literal such as {"a": 1, 2: "b"}. Keys
+// and Values are parallel and keep the order of the source.
+type HashLiteral struct {
+    Token  token.Token // the '{' token
+    Keys   []Expression
+    Values []Expression
+    Rbrace token.Token // the closing } token
+}
+
+func (hl *HashLiteral) expressionNode()      {}
+func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) String() string {
+    pairs := []string{}
+    for i, key := range hl.Keys {
+        pairs = append(pairs, key.String()+": "+hl.Values[i].String())
+    }
+    return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Span.Start }
+func (hl *HashLiteral) End() token.Position {
+    if hl.Rbrace.Type != "" {
+        return hl.Rbrace.Span.End
+    }
+    if len(hl.Values) > 0 {
+        return endOf(hl.Values[len(hl.Values)-1], hl.Token)
+    }
+    return hl.Token.Span.End
+}
+
+// MemberExpression represents a.b, which looks up the string key "b",
+// the same as a["b"]
+type MemberExpression struct {
+    Token    token.Token // the . token
+    Object   Expression
+    Property *Identifier
+}
+
+func (me *MemberExpression) expressionNode()      {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) String() string {
+    return "(" + me.Object.String() + "." + me.Property.String() + ")"
+}
+
+func (me *MemberExpression) Pos() token.Position { return startOf(me.Object, me.Token) }
+func (me *MemberExpression) End() token.Position { return endOf(me.Property, me.Token) }
+
+// startOf returns n's start position, falling back to tok when n is
+// missing because of a parse error
+func startOf(n Node, tok token.Token) token.Position {
+    if isNil(n) {
+        return tok.Span.Start
+    }
+    return n.Pos()
+}
+
+// endOf returns n's end position, falling back to tok when n is missing
+func endOf(n Node, tok token.Token) token.Position {
+    if isNil(n) {
+        return tok.Span.End
+    }
+    return n.End()
+}
+
+func isNil(n Node) bool {
+    if n == nil {
+        return true
+    }
+    v := reflect.ValueOf(n)
+    return v.Kind() == reflect.Ptr && v.IsNil()
+}
@@ -0,0 +1,105 @@
+package ast
+
+import (
+    "fmt"
+    "io"
+    "reflect"
+    "strings"
+    "github.com/RavenStorm-bit/toy-compiler/token"
+)
+
+var (
+    nodeType   = reflect.TypeOf((*Node)(nil)).Elem()
+    tokenType  = reflect.TypeOf(token.Token{})
+    tokensType = reflect.TypeOf([]token.Token(nil))
+)
+
+// Fprint writes a syntax tree one node per line, indented by depth.
+// Scalar fields are shown inline after the node's type and position.
+func Fprint(w io.Writer, n Node) {
+    fprintNode(w, n, "", 0)
+}
+
+func fprintNode(w io.Writer, n Node, label string, depth int) {
+    indent := strings.Repeat("  ", depth)
+    v := reflect.ValueOf(n)
+    if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+        fmt.Fprintf(w, "%s%snil\n", indent, label)
+        return
+    }
+
+    var scalars []string
+    type child struct {
+        label string
+        node  Node
+    }
+    var children []child
+
+    EachField(n, func(name string, f reflect.Value) {
+        switch {
+        case f.Type().Implements(nodeType):
+            var node Node
+            if !f.IsNil() {
+                node = f.Interface().(Node)
+            }
+            children = append(children, child{name + ": ", node})
+        case f.Kind() == reflect.Slice && f.Type().Elem().Implements(nodeType):
+            for i := 0; i < f.Len(); i++ {
+                children = append(children, child{"", f.Index(i).Interface().(Node)})
+            }
+        case f.Kind() == reflect.Ptr && f.IsNil():
+            // Unset optional fields are left out
+        case f.Kind() == reflect.String:
+            if f.String() != "" {
+                scalars = append(scalars, fmt.Sprintf("%s=%q", name, f.String()))
+            }
+        default:
+            scalars = append(scalars, fmt.Sprintf("%s=%v", name, f.Interface()))
+        }
+    })
+
+    line := indent + label + v.Elem().Type().Name() + " " + n.Pos().String()
+    if len(scalars) > 0 {
+        line += " " + strings.Join(scalars, " ")
+    }
+    fmt.Fprintln(w, line)
+
+    for _, c := range children {
+        fprintNode(w, c.node, c.label, depth+1)
+    }
+}
+
+// EachField calls fn for the exported fields of the node n points to,
+// skipping the tokens the node was parsed from
+func EachField(n Node, fn func(name string, f reflect.Value)) {
+    s := reflect.ValueOf(n).Elem()
+    for i := 0; i < s.NumField(); i++ {
+        field := s.Type().Field(i)
+        if field.PkgPath != "" || field.Type == tokenType || field.Type == tokensType {
+            continue
+        }
+        fn(field.Name, s.Field(i))
+    }
+}
+
+// Inspect calls fn for n and, while fn returns true, for each node below
+// it in field order
+func Inspect(n Node, fn func(Node) bool) {
+    v := reflect.ValueOf(n)
+    if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) || !fn(n) {
+        return
+    }
+
+    EachField(n, func(name string, f reflect.Value) {
+        switch {
+        case f.Type().Implements(nodeType):
+            if !f.IsNil() {
+                Inspect(f.Interface().(Node), fn)
+            }
+        case f.Kind() == reflect.Slice && f.Type().Elem().Implements(nodeType):
+            for i := 0; i < f.Len(); i++ {
+                Inspect(f.Index(i).Interface().(Node), fn)
+            }
+        }
+    })
+}
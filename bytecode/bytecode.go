@@ -2,6 +2,8 @@ package bytecode
 
 import (
 	"fmt"
+
+	"github.com/RavenStorm-bit/toy-compiler/object"
 )
 
 // Instructions is a sequence of bytecode instructions
@@ -10,7 +12,8 @@ type Instructions []byte
 // Bytecode represents compiled bytecode
 type Bytecode struct {
 	Instructions Instructions
-	Constants    []interface{}
+	Constants    []object.Object
+	Positions    object.Positions // source positions of the instructions
 }
 
 // Opcode represents a single bytecode instruction
@@ -39,10 +42,73 @@ const (
 	OpNotEqual
 	// OpGreaterThan compares if left > right
 	OpGreaterThan
-	// OpJumpNotTrue jumps if top of stack is not true
+	// OpJumpNotTrue pops the top of stack and jumps if it is not truthy
 	OpJumpNotTrue
 	// OpJump unconditional jump
 	OpJump
+	// OpNull pushes null onto the stack
+	OpNull
+	// OpGetGlobal pushes the value of a global binding
+	OpGetGlobal
+	// OpSetGlobal pops a value into a global binding
+	OpSetGlobal
+	// OpGetLocal pushes the value of a local binding
+	OpGetLocal
+	// OpSetLocal pops a value into a local binding
+	OpSetLocal
+	// OpCall calls the function below its arguments on the stack
+	OpCall
+	// OpReturnValue returns the top of stack from the current function
+	OpReturnValue
+	// OpReturn returns null from the current function
+	OpReturn
+	// OpClosure wraps a compiled function constant and the free variables
+	// below it on the stack into a closure
+	OpClosure
+	// OpGetFree pushes one of the current closure's free variables
+	OpGetFree
+	// OpSetFree pops a value into one of the current closure's free variables
+	OpSetFree
+	// OpCurrentClosure pushes the closure being executed, for recursion
+	OpCurrentClosure
+	// OpGetBuiltin pushes a builtin function by its index in stdlib.Builtins
+	OpGetBuiltin
+	// OpLessThan compares if left < right
+	OpLessThan
+	// OpMinus negates the top of stack
+	OpMinus
+	// OpBang replaces the top of stack with its logical negation
+	OpBang
+	// OpArray builds an array from the given number of stack elements
+	OpArray
+	// OpIndex replaces an array or hash and an index or key with the
+	// element stored there
+	OpIndex
+	// OpSetIndex pops an array or hash, an index or key and a value, and
+	// stores the value
+	OpSetIndex
+	// OpHash builds a hash from the given number of stack elements, which
+	// alternate between keys and values
+	OpHash
+	// OpMod computes the remainder of left / right
+	OpMod
+	// OpLessEqual compares if left <= right
+	OpLessEqual
+	// OpGreaterEqual compares if left >= right
+	OpGreaterEqual
+	// OpIter replaces an array or hash with an iterator over it
+	OpIter
+	// OpIterNext pops an iterator and pushes the given number of loop
+	// variables for its next step, or jumps if it is exhausted
+	OpIterNext
+	// OpDup2 pushes copies of the top two stack values, keeping their order
+	OpDup2
+	// OpCaptureLocal moves a local binding into a cell, unless it is in one
+	// already, and pushes the cell for OpClosure to capture
+	OpCaptureLocal
+	// OpCaptureFree pushes the cell holding one of the current closure's
+	// free variables, for OpClosure to capture
+	OpCaptureFree
 )
 
 // Definition describes an opcode's structure
@@ -65,6 +131,35 @@ var definitions = map[Opcode]*Definition{
 	OpGreaterThan: {"OpGreaterThan", []int{}},
 	OpJumpNotTrue: {"OpJumpNotTrue", []int{2}},
 	OpJump:        {"OpJump", []int{2}},
+	OpNull:        {"OpNull", []int{}},
+	OpGetGlobal:   {"OpGetGlobal", []int{2}},
+	OpSetGlobal:   {"OpSetGlobal", []int{2}},
+	OpGetLocal:    {"OpGetLocal", []int{1}},
+	OpSetLocal:    {"OpSetLocal", []int{1}},
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpLessThan:       {"OpLessThan", []int{}},
+	OpMinus:          {"OpMinus", []int{}},
+	OpBang:           {"OpBang", []int{}},
+	OpArray:          {"OpArray", []int{2}},
+	OpIndex:          {"OpIndex", []int{}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpHash:           {"OpHash", []int{2}},
+	OpMod:            {"OpMod", []int{}},
+	OpLessEqual:      {"OpLessEqual", []int{}},
+	OpGreaterEqual:   {"OpGreaterEqual", []int{}},
+	OpIter:           {"OpIter", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2, 1}},
+	OpDup2:           {"OpDup2", []int{}},
+	OpCaptureLocal:   {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:    {"OpCaptureFree", []int{1}},
 }
 
 // Lookup returns the definition for an opcode
@@ -95,6 +190,8 @@ func Make(op Opcode, operands ...int) []byte {
 	for i, operand := range operands {
 		width := def.OperandWidths[i]
 		switch width {
+		case 1:
+			instruction[offset] = byte(operand)
 		case 2:
 			instruction[offset] = byte(operand >> 8)
 			instruction[offset+1] = byte(operand)
@@ -104,3 +201,20 @@ func Make(op Opcode, operands ...int) []byte {
 
 	return instruction
 }
+
+// MaxOperand returns the largest value an operand of the given width in
+// bytes can hold. Make truncates larger operands, so callers check them
+// against this first.
+func MaxOperand(width int) int {
+	return 1<<(8*width) - 1
+}
+
+// ReadUint16 decodes a big-endian two-byte operand
+func ReadUint16(ins Instructions) uint16 {
+	return uint16(ins[0])<<8 | uint16(ins[1])
+}
+
+// ReadUint8 decodes a one-byte operand
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
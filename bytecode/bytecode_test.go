@@ -0,0 +1,107 @@
+package bytecode
+
+import (
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/object"
+)
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65535
+0009 OpClosure 65535 255
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, concatted.String())
+	}
+}
+
+func TestDisassembleConstants(t *testing.T) {
+	fn := &object.CompiledFunction{
+		Instructions:  append(Make(OpGetLocal, 0), Make(OpReturnValue)...),
+		NumLocals:     1,
+		NumParameters: 1,
+	}
+
+	bc := &Bytecode{
+		Instructions: append(Make(OpConstant, 0), Make(OpClosure, 1, 0)...),
+		Constants:    []object.Object{&object.String{Value: "hello"}, fn},
+	}
+
+	expected := `== main ==
+0000 OpConstant 0 ; "hello"
+0003 OpClosure 1 0 ; fn(params=1, locals=1)
+
+== constant 1: fn(params=1, locals=1) ==
+0000 OpGetLocal 0
+0002 OpReturnValue
+`
+
+	if got := bc.Disassemble(); got != expected {
+		t.Errorf("wrong disassembly.\nwant=%q\ngot=%q", expected, got)
+	}
+}
+
+func TestDisassembleNilConstant(t *testing.T) {
+	bc := &Bytecode{
+		Instructions: Make(OpConstant, 0),
+		Constants:    []object.Object{nil},
+	}
+
+	expected := `== main ==
+0000 OpConstant 0 ; <nil>
+`
+
+	if got := bc.Disassemble(); got != expected {
+		t.Errorf("wrong disassembly.\nwant=%q\ngot=%q", expected, got)
+	}
+}
@@ -0,0 +1,140 @@
+package bytecode
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/RavenStorm-bit/toy-compiler/object"
+)
+
+// ReadOperands decodes the operands of one instruction whose opcode has
+// already been read. It returns the operands and how many bytes they took.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+// String disassembles the instructions, one per line, prefixed with
+// their offsets
+func (ins Instructions) String() string {
+	return disassemble(ins, nil)
+}
+
+// FormatInstruction formats the single instruction at the start of ins,
+// without an offset or constant annotation
+func FormatInstruction(ins Instructions) string {
+	def, err := Lookup(ins[0])
+	if err != nil {
+		return "ERROR: " + err.Error()
+	}
+	if 1+operandsWidth(def) > len(ins) {
+		return "ERROR: " + def.Name + " truncated"
+	}
+
+	operands, _ := ReadOperands(def, ins[1:])
+	return fmtInstruction(def, operands)
+}
+
+// Disassemble lists the main instructions followed by every compiled
+// function in the constant pool. Constant operands are annotated with the
+// value they refer to.
+func (b *Bytecode) Disassemble() string {
+	var out bytes.Buffer
+
+	out.WriteString("== main ==\n")
+	out.WriteString(disassemble(b.Instructions, b.Constants))
+
+	for i, c := range b.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&out, "\n== constant %d: fn(params=%d, locals=%d) ==\n",
+			i, fn.NumParameters, fn.NumLocals)
+		out.WriteString(disassemble(fn.Instructions, b.Constants))
+	}
+
+	return out.String()
+}
+
+func disassemble(ins Instructions, constants []object.Object) string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+
+		if i+1+operandsWidth(def) > len(ins) {
+			fmt.Fprintf(&out, "%04d ERROR: %s truncated\n", i, def.Name)
+			break
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s", i, fmtInstruction(def, operands))
+
+		if comment := constantComment(Opcode(ins[i]), operands, constants); comment != "" {
+			out.WriteString(" ; " + comment)
+		}
+		out.WriteString("\n")
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func operandsWidth(def *Definition) int {
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	return width
+}
+
+func fmtInstruction(def *Definition, operands []int) string {
+	var out bytes.Buffer
+	out.WriteString(def.Name)
+	for _, o := range operands {
+		fmt.Fprintf(&out, " %d", o)
+	}
+	return out.String()
+}
+
+// constantComment describes the constant an instruction refers to
+func constantComment(op Opcode, operands []int, constants []object.Object) string {
+	if op != OpConstant && op != OpClosure {
+		return ""
+	}
+	if operands[0] >= len(constants) {
+		return ""
+	}
+
+	switch c := constants[operands[0]].(type) {
+	case nil:
+		return "<nil>"
+	case *object.String:
+		return strconv.Quote(c.Value)
+	case *object.CompiledFunction:
+		return fmt.Sprintf("fn(params=%d, locals=%d)", c.NumParameters, c.NumLocals)
+	default:
+		return c.Inspect()
+	}
+}
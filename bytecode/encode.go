@@ -0,0 +1,323 @@
+package bytecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+
+	"github.com/RavenStorm-bit/toy-compiler/object"
+	"github.com/RavenStorm-bit/toy-compiler/token"
+)
+
+// A .tbc file is laid out as
+//
+//	magic        4 bytes  "TBC\x00"
+//	version      uint16
+//	constants    uint32 count, then one tagged entry per constant
+//	instructions uint32 length, then the raw instruction bytes
+//	positions    the position table of the instructions
+//	checksum     uint32 CRC-32 (IEEE) of every preceding byte
+//
+// A compiled function constant likewise ends with its position table. A
+// position table is the source file name as a uint32 length and bytes,
+// then a uint32 count of entries, each an instruction offset, line and
+// column as uint32s. All integers are big-endian.
+
+// Magic identifies a serialized bytecode file
+var Magic = [4]byte{'T', 'B', 'C', 0}
+
+// FormatVersion is the version of the file format written by Encode.
+// Version 2 added the source of compiled functions and version 3 the
+// position tables.
+const FormatVersion uint16 = 3
+
+// Constant pool tags
+const (
+	tagInteger  byte = 1
+	tagString   byte = 2
+	tagFunction byte = 3
+	tagFloat    byte = 4
+	tagBigInt   byte = 5
+)
+
+var (
+	// ErrBadMagic is returned when decoding data that is not bytecode
+	ErrBadMagic = errors.New("not a toy bytecode file")
+	// ErrChecksum is returned when the file has been truncated or altered
+	ErrChecksum = errors.New("bytecode checksum mismatch")
+)
+
+// Encode writes b in the .tbc format
+func (b *Bytecode) Encode(w io.Writer) error {
+	var buf bytes.Buffer
+
+	buf.Write(Magic[:])
+	writeUint16(&buf, FormatVersion)
+
+	writeUint32(&buf, uint32(len(b.Constants)))
+	for i, c := range b.Constants {
+		if err := encodeConstant(&buf, c); err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	writeBytes(&buf, b.Instructions)
+	writePositions(&buf, b.Positions)
+
+	writeUint32(&buf, crc32.ChecksumIEEE(buf.Bytes()))
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func encodeConstant(buf *bytes.Buffer, c object.Object) error {
+	switch c := c.(type) {
+	case *object.Integer:
+		buf.WriteByte(tagInteger)
+		writeUint64(buf, uint64(c.Value))
+	case *object.BigInt:
+		// A sign byte, then the magnitude's big-endian bytes
+		buf.WriteByte(tagBigInt)
+		buf.WriteByte(byte(c.Value.Sign() + 1))
+		writeBytes(buf, c.Value.Bytes())
+	case *object.Float:
+		buf.WriteByte(tagFloat)
+		writeUint64(buf, math.Float64bits(c.Value))
+	case *object.String:
+		buf.WriteByte(tagString)
+		writeBytes(buf, []byte(c.Value))
+	case *object.CompiledFunction:
+		buf.WriteByte(tagFunction)
+		writeUint16(buf, uint16(c.NumLocals))
+		writeUint16(buf, uint16(c.NumParameters))
+		writeBytes(buf, c.Instructions)
+		writeBytes(buf, []byte(c.Source))
+		writePositions(buf, c.Positions)
+	default:
+		return fmt.Errorf("cannot encode constant of type %s", c.Type())
+	}
+	return nil
+}
+
+// Decode reads bytecode written by Encode. The checksum and version are
+// checked before anything else is decoded.
+func Decode(r io.Reader) (*Bytecode, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(Magic) || !bytes.Equal(data[:len(Magic)], Magic[:]) {
+		return nil, ErrBadMagic
+	}
+	if len(data) < len(Magic)+2+4 {
+		return nil, ErrChecksum
+	}
+
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, ErrChecksum
+	}
+
+	d := &decoder{data: body, pos: len(Magic)}
+
+	version := d.readUint16()
+	if version != FormatVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d (want %d)", version, FormatVersion)
+	}
+
+	count := d.readUint32()
+	if d.err == nil && int(count) > len(d.data) {
+		d.fail("constant count %d exceeds file size", count)
+	}
+
+	constants := make([]object.Object, 0, int(count))
+	for i := 0; i < int(count) && d.err == nil; i++ {
+		c := d.constant()
+		if d.err == nil {
+			constants = append(constants, c)
+		}
+	}
+
+	instructions := d.readBytes()
+	positions := d.positions()
+
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail("%d unexpected trailing bytes", len(d.data)-d.pos)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return &Bytecode{Instructions: instructions, Constants: constants, Positions: positions}, nil
+}
+
+// decoder reads big-endian values from data, remembering the first error
+// so callers can check once at the end
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("malformed bytecode at offset %d: %s", d.pos, fmt.Sprintf(format, args...))
+	}
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.pos+n > len(d.data) {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) readByte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) readUint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) readUint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) readUint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) readBytes() []byte {
+	n := d.readUint32()
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func (d *decoder) positions() object.Positions {
+	filename := string(d.readBytes())
+	count := d.readUint32()
+	if d.err == nil && int(count) > len(d.data)/12 {
+		d.fail("position count %d exceeds file size", count)
+	}
+	if d.err != nil || count == 0 {
+		return nil
+	}
+
+	positions := make(object.Positions, 0, int(count))
+	for i := 0; i < int(count) && d.err == nil; i++ {
+		offset := d.readUint32()
+		line := d.readUint32()
+		column := d.readUint32()
+		positions = append(positions, object.InstructionPosition{
+			Offset: int(offset),
+			Pos:    token.Position{Filename: filename, Line: int(line), Column: int(column)},
+		})
+	}
+	return positions
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.readByte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: int64(d.readUint64())}
+	case tagBigInt:
+		sign := d.readByte()
+		v := new(big.Int).SetBytes(d.readBytes())
+		if sign > 2 {
+			d.fail("invalid integer sign %d", sign)
+			return nil
+		}
+		if sign == 0 {
+			v.Neg(v)
+		}
+		return object.NewInteger(v)
+	case tagFloat:
+		return &object.Float{Value: math.Float64frombits(d.readUint64())}
+	case tagString:
+		return &object.String{Value: string(d.readBytes())}
+	case tagFunction:
+		numLocals := d.readUint16()
+		numParameters := d.readUint16()
+		instructions := d.readBytes()
+		source := d.readBytes()
+		positions := d.positions()
+		return &object.CompiledFunction{
+			NumLocals:     int(numLocals),
+			NumParameters: int(numParameters),
+			Instructions:  instructions,
+			Source:        string(source),
+			Positions:     positions,
+		}
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
+
+// writePositions writes a position table. The compiler builds each table
+// from a single file, so the file name is stored once.
+func writePositions(buf *bytes.Buffer, positions object.Positions) {
+	filename := ""
+	if len(positions) > 0 {
+		filename = positions[0].Pos.Filename
+	}
+	writeBytes(buf, []byte(filename))
+	writeUint32(buf, uint32(len(positions)))
+	for _, p := range positions {
+		writeUint32(buf, uint32(p.Offset))
+		writeUint32(buf, uint32(p.Pos.Line))
+		writeUint32(buf, uint32(p.Pos.Column))
+	}
+}
+
+func writeUint16(buf *bytes.Buffer, v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeUint32(buf, uint32(len(b)))
+	buf.Write(b)
+}
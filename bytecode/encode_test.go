@@ -0,0 +1,97 @@
+package bytecode
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/object"
+	"github.com/RavenStorm-bit/toy-compiler/token"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	fn := &object.CompiledFunction{
+		Instructions:  append(Make(OpGetLocal, 0), Make(OpReturnValue)...),
+		NumLocals:     3,
+		NumParameters: 2,
+		Source:        "fn(a, b) {\na\n}",
+		Positions: object.Positions{
+			{Offset: 0, Pos: token.Position{Filename: "f.toy", Line: 2, Column: 3}},
+			{Offset: 2, Pos: token.Position{Filename: "f.toy", Line: 2, Column: 5}},
+		},
+	}
+	bc := &Bytecode{
+		Instructions: append(Make(OpConstant, 0), Make(OpClosure, 2, 0)...),
+		Constants: []object.Object{
+			&object.Integer{Value: -42},
+			&object.String{Value: "héllo\n"},
+			fn,
+			&object.Float{Value: -0.125},
+			&object.BigInt{Value: new(big.Int).Lsh(big.NewInt(-3), 100)},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := bc.Encode(&buf); err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+
+	if decoded.Disassemble() != bc.Disassemble() {
+		t.Errorf("round trip changed the bytecode.\nwant=%s\ngot=%s",
+			bc.Disassemble(), decoded.Disassemble())
+	}
+
+	got := decoded.Constants[2].(*object.CompiledFunction)
+	if got.NumLocals != 3 || got.NumParameters != 2 {
+		t.Errorf("function counts wrong. got locals=%d params=%d",
+			got.NumLocals, got.NumParameters)
+	}
+	if got.Source != fn.Source {
+		t.Errorf("function source wrong. want=%q, got=%q", fn.Source, got.Source)
+	}
+	if !reflect.DeepEqual(got.Positions, fn.Positions) {
+		t.Errorf("function positions wrong. want=%v, got=%v", fn.Positions, got.Positions)
+	}
+	if decoded.Positions != nil {
+		t.Errorf("main positions wrong. want none, got %v", decoded.Positions)
+	}
+}
+
+func TestDecodeRejectsBadInput(t *testing.T) {
+	bc := &Bytecode{
+		Instructions: Make(OpConstant, 0),
+		Constants:    []object.Object{&object.Integer{Value: 1}},
+	}
+	var buf bytes.Buffer
+	if err := bc.Encode(&buf); err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+	good := buf.Bytes()
+
+	corrupted := append([]byte{}, good...)
+	corrupted[len(corrupted)-6] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"not bytecode", []byte("let x = 1;"), ErrBadMagic},
+		{"truncated", good[:len(good)-3], ErrChecksum},
+		{"corrupted", corrupted, ErrChecksum},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: wrong error. want=%v, got=%v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package bytecode
+
+import (
+	"fmt"
+
+	"github.com/RavenStorm-bit/toy-compiler/object"
+)
+
+// VerifyError describes why an instruction stream was rejected
+type VerifyError struct {
+	Function int // constant index of the function, or -1 for main
+	Offset   int // offset of the offending instruction
+	Message  string
+}
+
+func (e *VerifyError) Error() string {
+	where := "main"
+	if e.Function >= 0 {
+		where = fmt.Sprintf("constant %d", e.Function)
+	}
+	return fmt.Sprintf("invalid bytecode in %s at %04d: %s", where, e.Offset, e.Message)
+}
+
+// Verify checks that b can be executed without the VM reading past an
+// instruction stream or its constant pool. Each instruction stream must
+// consist of defined opcodes with complete operands, refer only to
+// existing, non-nil constants and locals, jump only to instruction boundaries, and
+// keep the operand stack at a consistent, non-negative depth along every
+// path. Functions must return on every path.
+func Verify(b *Bytecode) error {
+	numFree, err := closureSites(b)
+	if err != nil {
+		return err
+	}
+
+	main := &streamVerifier{
+		ins:       b.Instructions,
+		constants: b.Constants,
+		fn:        -1,
+	}
+	if err := main.verify(); err != nil {
+		return err
+	}
+
+	for i, c := range b.Constants {
+		if c == nil {
+			return &VerifyError{Function: -1, Message: fmt.Sprintf("constant %d is nil", i)}
+		}
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		if fn.NumParameters > fn.NumLocals {
+			return &VerifyError{Function: i, Message: fmt.Sprintf(
+				"%d parameters but only %d locals", fn.NumParameters, fn.NumLocals)}
+		}
+
+		v := &streamVerifier{
+			ins:       fn.Instructions,
+			constants: b.Constants,
+			fn:        i,
+			numLocals: fn.NumLocals,
+			numFree:   numFree[i],
+		}
+		if err := v.verify(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closureSites checks that every OpClosure refers to a compiled function
+// and returns how many free variables each function is closed over with.
+func closureSites(b *Bytecode) (map[int]int, error) {
+	numFree := map[int]int{}
+
+	type stream struct {
+		fn  int
+		ins Instructions
+	}
+	streams := []stream{{-1, b.Instructions}}
+	for i, c := range b.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			streams = append(streams, stream{i, fn.Instructions})
+		}
+	}
+
+	for _, s := range streams {
+		fnIndex := s.fn
+		err := walk(fnIndex, s.ins, func(pos int, op Opcode, operands []int) error {
+			if op != OpClosure {
+				return nil
+			}
+			constIndex, free := operands[0], operands[1]
+			if constIndex >= len(b.Constants) {
+				return nil // reported with the other constant checks
+			}
+			if _, ok := b.Constants[constIndex].(*object.CompiledFunction); !ok {
+				return &VerifyError{Function: fnIndex, Offset: pos, Message: fmt.Sprintf(
+					"OpClosure on constant %d, which is not a function", constIndex)}
+			}
+			if prev, ok := numFree[constIndex]; ok && prev != free {
+				return &VerifyError{Function: fnIndex, Offset: pos, Message: fmt.Sprintf(
+					"constant %d closed over with %d free variables, elsewhere %d",
+					constIndex, free, prev)}
+			}
+			numFree[constIndex] = free
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return numFree, nil
+}
+
+// walk decodes ins linearly, calling visit for each instruction
+func walk(fn int, ins Instructions, visit func(pos int, op Opcode, operands []int) error) error {
+	pos := 0
+	for pos < len(ins) {
+		def, err := Lookup(ins[pos])
+		if err != nil {
+			return &VerifyError{Function: fn, Offset: pos, Message: err.Error()}
+		}
+		if pos+1+operandsWidth(def) > len(ins) {
+			return &VerifyError{Function: fn, Offset: pos, Message: def.Name + " is truncated"}
+		}
+
+		operands, read := ReadOperands(def, ins[pos+1:])
+		if err := visit(pos, Opcode(ins[pos]), operands); err != nil {
+			return err
+		}
+		pos += 1 + read
+	}
+	return nil
+}
+
+type streamVerifier struct {
+	ins       Instructions
+	constants []object.Object
+	fn        int
+	numLocals int
+	numFree   int
+
+	boundaries map[int]bool
+}
+
+func (v *streamVerifier) errorf(pos int, format string, args ...interface{}) error {
+	return &VerifyError{Function: v.fn, Offset: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+func (v *streamVerifier) verify() error {
+	v.boundaries = map[int]bool{}
+
+	err := walk(v.fn, v.ins, func(pos int, op Opcode, operands []int) error {
+		v.boundaries[pos] = true
+		return v.checkOperands(pos, op, operands)
+	})
+	if err != nil {
+		return err
+	}
+
+	return v.checkStack()
+}
+
+func (v *streamVerifier) checkOperands(pos int, op Opcode, operands []int) error {
+	switch op {
+	case OpConstant, OpClosure:
+		if operands[0] >= len(v.constants) {
+			return v.errorf(pos, "constant index %d out of range (pool has %d)",
+				operands[0], len(v.constants))
+		}
+	case OpGetLocal, OpSetLocal, OpCaptureLocal:
+		if operands[0] >= v.numLocals {
+			return v.errorf(pos, "local index %d out of range (function has %d)",
+				operands[0], v.numLocals)
+		}
+	case OpGetFree, OpSetFree, OpCaptureFree:
+		if operands[0] >= v.numFree {
+			return v.errorf(pos, "free variable index %d out of range (closure has %d)",
+				operands[0], v.numFree)
+		}
+	case OpCurrentClosure:
+		if v.fn < 0 {
+			return v.errorf(pos, "OpCurrentClosure outside a function")
+		}
+	case OpHash:
+		if operands[0]%2 != 0 {
+			return v.errorf(pos, "OpHash needs key/value pairs, got %d elements", operands[0])
+		}
+	case OpIterNext:
+		if operands[1] != 1 && operands[1] != 2 {
+			return v.errorf(pos, "OpIterNext takes 1 or 2 loop variables, got %d", operands[1])
+		}
+	}
+	return nil
+}
+
+// checkStack follows every path through the stream, tracking the operand
+// stack depth before each instruction
+func (v *streamVerifier) checkStack() error {
+	depths := map[int]int{}
+	worklist := []int{}
+
+	enter := func(from, target, depth int) error {
+		if target == len(v.ins) {
+			if v.fn >= 0 {
+				return v.errorf(from, "function can end without returning")
+			}
+			return nil
+		}
+		if !v.boundaries[target] {
+			return v.errorf(from, "jump target %d is not an instruction boundary", target)
+		}
+		if seen, ok := depths[target]; ok {
+			if seen != depth {
+				return v.errorf(target, "inconsistent stack depth: %d or %d", seen, depth)
+			}
+			return nil
+		}
+		depths[target] = depth
+		worklist = append(worklist, target)
+		return nil
+	}
+
+	if err := enter(0, 0, 0); err != nil {
+		return err
+	}
+
+	for len(worklist) > 0 {
+		pos := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		depth := depths[pos]
+
+		op := Opcode(v.ins[pos])
+		def, _ := Lookup(byte(op))
+		operands, read := ReadOperands(def, v.ins[pos+1:])
+		next := pos + 1 + read
+
+		pops, pushes, ok := stackEffect(op, operands)
+		if !ok {
+			return v.errorf(pos, "no stack effect known for %s", def.Name)
+		}
+		if depth < pops {
+			return v.errorf(pos, "%s needs %d stack values, has %d", def.Name, pops, depth)
+		}
+		depth = depth - pops + pushes
+
+		switch op {
+		case OpReturnValue, OpReturn:
+			continue
+		case OpJump:
+			if err := enter(pos, operands[0], depth); err != nil {
+				return err
+			}
+			continue
+		case OpJumpNotTrue:
+			if err := enter(pos, operands[0], depth); err != nil {
+				return err
+			}
+		case OpIterNext:
+			// The loop variables are only pushed when it does not jump
+			if err := enter(pos, operands[0], depth-pushes); err != nil {
+				return err
+			}
+		}
+
+		if err := enter(pos, next, depth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stackEffect returns how many values op pops and then pushes
+func stackEffect(op Opcode, operands []int) (pops, pushes int, ok bool) {
+	switch op {
+	case OpConstant, OpTrue, OpFalse, OpNull, OpGetGlobal, OpGetLocal,
+		OpGetFree, OpGetBuiltin, OpCurrentClosure, OpCaptureLocal, OpCaptureFree:
+		return 0, 1, true
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpEqual, OpNotEqual,
+		OpGreaterThan, OpLessThan, OpGreaterEqual, OpLessEqual:
+		return 2, 1, true
+	case OpMinus, OpBang, OpIter:
+		return 1, 1, true
+	case OpIterNext:
+		return 1, operands[1], true
+	case OpDup2:
+		return 2, 4, true
+	case OpArray, OpHash:
+		return operands[0], 1, true
+	case OpIndex:
+		return 2, 1, true
+	case OpSetIndex:
+		return 3, 0, true
+	case OpPop, OpJumpNotTrue, OpSetGlobal, OpSetLocal, OpSetFree, OpReturnValue:
+		return 1, 0, true
+	case OpJump, OpReturn:
+		return 0, 0, true
+	case OpCall:
+		return operands[0] + 1, 1, true
+	case OpClosure:
+		return operands[1], 1, true
+	}
+	return 0, 0, false
+}
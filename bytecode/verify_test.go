@@ -0,0 +1,170 @@
+package bytecode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/object"
+)
+
+func concat(instructions ...[]byte) Instructions {
+	out := Instructions{}
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func TestVerifyAcceptsWellFormed(t *testing.T) {
+	fn := &object.CompiledFunction{
+		Instructions: concat(
+			Make(OpGetLocal, 0),
+			Make(OpGetFree, 0),
+			Make(OpAdd),
+			Make(OpReturnValue),
+		),
+		NumLocals:     1,
+		NumParameters: 1,
+	}
+	// Both branches of the conditional push one value, which becomes the
+	// closure's free variable.
+	bc := &Bytecode{
+		Instructions: concat(
+			Make(OpTrue),            // 0000
+			Make(OpJumpNotTrue, 10), // 0001
+			Make(OpConstant, 0),     // 0004
+			Make(OpJump, 11),        // 0007
+			Make(OpNull),            // 0010
+			Make(OpClosure, 1, 1),   // 0011
+			Make(OpConstant, 0),     // 0015
+			Make(OpCall, 1),         // 0018
+			Make(OpPop),             // 0020
+		),
+		Constants: []object.Object{&object.Integer{Value: 1}, fn},
+	}
+
+	if err := Verify(bc); err != nil {
+		t.Fatalf("well-formed bytecode rejected: %s", err)
+	}
+}
+
+func TestVerifyRejectsMalformed(t *testing.T) {
+	fn := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(ins...), NumLocals: numLocals}
+	}
+
+	tests := []struct {
+		name      string
+		bc        *Bytecode
+		errSubstr string
+	}{
+		{
+			"undefined opcode",
+			&Bytecode{Instructions: Instructions{255}},
+			"opcode 255 undefined",
+		},
+		{
+			"truncated operand",
+			&Bytecode{Instructions: Make(OpConstant, 0)[:2]},
+			"OpConstant is truncated",
+		},
+		{
+			"constant out of range",
+			&Bytecode{Instructions: Make(OpConstant, 3)},
+			"constant index 3 out of range",
+		},
+		{
+			"hash with a key but no value",
+			&Bytecode{Instructions: concat(Make(OpTrue), Make(OpHash, 1), Make(OpPop))},
+			"OpHash needs key/value pairs",
+		},
+		{
+			"iterator step with three variables",
+			&Bytecode{Instructions: concat(Make(OpNull), Make(OpIterNext, 4, 3))},
+			"OpIterNext takes 1 or 2 loop variables, got 3",
+		},
+		{
+			"stack underflow",
+			&Bytecode{Instructions: Make(OpPop)},
+			"OpPop needs 1 stack values, has 0",
+		},
+		{
+			"jump into operand",
+			&Bytecode{
+				Instructions: concat(Make(OpJump, 4), Make(OpConstant, 0)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"jump target 4 is not an instruction boundary",
+		},
+		{
+			"unbalanced branches",
+			&Bytecode{
+				Instructions: concat(
+					Make(OpTrue),           // 0000
+					Make(OpJumpNotTrue, 5), // 0001
+					Make(OpNull),           // 0004
+					Make(OpNull),           // 0005
+				),
+			},
+			"inconsistent stack depth",
+		},
+		{
+			"local in main",
+			&Bytecode{Instructions: Make(OpGetLocal, 0)},
+			"local index 0 out of range",
+		},
+		{
+			"function falls off the end",
+			&Bytecode{
+				Instructions: Make(OpClosure, 0, 0),
+				Constants:    []object.Object{fn(0, Make(OpNull), Make(OpPop))},
+			},
+			"function can end without returning",
+		},
+		{
+			"closure over non-function",
+			&Bytecode{
+				Instructions: Make(OpClosure, 0, 0),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"not a function",
+		},
+		{
+			"nil constant",
+			&Bytecode{Instructions: Make(OpConstant, 0), Constants: []object.Object{nil}},
+			"constant 0 is nil",
+		},
+		{
+			"free variable out of range",
+			&Bytecode{
+				Instructions: Make(OpClosure, 0, 0),
+				Constants:    []object.Object{fn(0, Make(OpGetFree, 0), Make(OpReturnValue))},
+			},
+			"free variable index 0 out of range",
+		},
+		{
+			"captured free variable out of range",
+			&Bytecode{
+				Instructions: Make(OpClosure, 0, 0),
+				Constants:    []object.Object{fn(0, Make(OpCaptureFree, 0), Make(OpReturnValue))},
+			},
+			"free variable index 0 out of range",
+		},
+		{
+			"captured local in main",
+			&Bytecode{Instructions: Make(OpCaptureLocal, 0)},
+			"local index 0 out of range",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.bc)
+		if err == nil {
+			t.Errorf("%s: malformed bytecode accepted", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.errSubstr) {
+			t.Errorf("%s: wrong error. want substring %q, got %q", tt.name, tt.errSubstr, err)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+// Package cli implements the toy command: a single binary whose
+// subcommands run, inspect and build toy programs.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/RavenStorm-bit/toy-compiler/ast"
+	"github.com/RavenStorm-bit/toy-compiler/bytecode"
+	"github.com/RavenStorm-bit/toy-compiler/compiler"
+	"github.com/RavenStorm-bit/toy-compiler/format"
+	"github.com/RavenStorm-bit/toy-compiler/lexer"
+	"github.com/RavenStorm-bit/toy-compiler/parser"
+	"github.com/RavenStorm-bit/toy-compiler/repl"
+	"github.com/RavenStorm-bit/toy-compiler/runner"
+	"github.com/RavenStorm-bit/toy-compiler/stdlib"
+	"github.com/RavenStorm-bit/toy-compiler/token"
+)
+
+// stdinName is the filename reported for programs read from stdin
+const stdinName = "<stdin>"
+
+// Flags shared by every subcommand. A command rejects the ones it has no
+// use for rather than silently ignoring them.
+const (
+	flagBackend = "backend"
+	flagTrace   = "trace"
+	flagJSON    = "json"
+)
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	flags   []string // the shared flags the command accepts
+	run     func(e *env, in *input) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"run", "[file]", "run a program or a .tbc bytecode file",
+			[]string{flagBackend, flagTrace, flagJSON}, runCmd},
+		{"repl", "", "start an interactive session",
+			[]string{flagBackend}, replCmd},
+		{"tokens", "[file]", "print the tokens of a program",
+			[]string{flagJSON}, tokensCmd},
+		{"ast", "[file]", "print the syntax tree of a program",
+			[]string{flagJSON}, astCmd},
+		{"disasm", "[file]", "disassemble a program or a .tbc bytecode file",
+			nil, disasmCmd},
+		{"build", "[-o output] [file]", "compile a program to a .tbc bytecode file",
+			nil, buildCmd},
+		{"fmt", "[file]", "print a program in canonical format",
+			nil, fmtCmd},
+		{"check", "[file]", "report syntax and compile errors without running",
+			[]string{flagJSON}, checkCmd},
+	}
+}
+
+// env is the state of one invocation of the toy command
+type env struct {
+	cmd    *command
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	backend string
+	trace   bool
+	json    bool
+	output  string // build -o
+}
+
+// input is a program read from a file or stdin
+type input struct {
+	name string
+	data []byte
+}
+
+// Main runs the toy command with args, which exclude the program name,
+// and returns the process exit code
+func Main(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return runner.ExitUsage
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(stdout)
+		return runner.ExitOK
+	}
+
+	cmd := lookup(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "toy: unknown command %q\n", args[0])
+		usage(stderr)
+		return runner.ExitUsage
+	}
+
+	e := &env{cmd: cmd, stdin: stdin, stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet("toy "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&e.backend, flagBackend, "", "execution backend: vm or eval")
+	fs.BoolVar(&e.trace, flagTrace, false, "write each executed VM instruction to stderr")
+	fs.BoolVar(&e.json, flagJSON, false, "write machine-readable JSON to stdout")
+	if cmd.name == "build" {
+		fs.StringVar(&e.output, "o", "", "output file (default: source name with "+runner.BytecodeExt+")")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: toy %s [flags] %s\n", cmd.name, cmd.usage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return runner.ExitOK
+		}
+		return runner.ExitUsage
+	}
+	if code := e.checkFlags(fs); code != runner.ExitOK {
+		return code
+	}
+
+	// print writes to the same stdout as the command's own output
+	stdlib.Output = stdout
+
+	if cmd.name == "repl" {
+		if fs.NArg() > 0 {
+			return e.usageError("repl does not take a file")
+		}
+		return cmd.run(e, nil)
+	}
+
+	if fs.NArg() > 1 {
+		return e.usageError("too many arguments")
+	}
+	in, err := e.read(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return runner.ExitIOError
+	}
+	return cmd.run(e, in)
+}
+
+func lookup(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: toy <command> [flags] [file]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Programs are read from file, or from stdin when file is omitted or -.")
+	fmt.Fprintln(w, "Run 'toy <command> -h' for the flags of a command.")
+}
+
+// checkFlags rejects shared flags the command does not support and
+// validates their values
+func (e *env) checkFlags(fs *flag.FlagSet) int {
+	var unsupported []string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "o" {
+			return
+		}
+		for _, name := range e.cmd.flags {
+			if f.Name == name {
+				return
+			}
+		}
+		unsupported = append(unsupported, "--"+f.Name)
+	})
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return e.usageError(strings.Join(unsupported, ", ") + " is not supported")
+	}
+
+	switch runner.Backend(e.backend) {
+	case "", runner.BackendVM, runner.BackendEval:
+	default:
+		return e.usageError(fmt.Sprintf("unknown backend %q (want vm or eval)", e.backend))
+	}
+	if e.trace && runner.Backend(e.backend) == runner.BackendEval {
+		return e.usageError("--trace requires the vm backend")
+	}
+	return runner.ExitOK
+}
+
+func (e *env) usageError(msg string) int {
+	fmt.Fprintf(e.stderr, "toy %s: %s\n", e.cmd.name, msg)
+	return runner.ExitUsage
+}
+
+func (e *env) read(filename string) (*input, error) {
+	if filename == "" || filename == "-" {
+		data, err := ioutil.ReadAll(e.stdin)
+		if err != nil {
+			return nil, fmt.Errorf("could not read stdin: %w", err)
+		}
+		return &input{name: stdinName, data: data}, nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file %s: %w", filename, err)
+	}
+	return &input{name: filename, data: data}, nil
+}
+
+// filename is the name recorded in token positions: empty for stdin, so
+// messages about it show only line and column
+func (in *input) filename() string {
+	if in.name == stdinName {
+		return ""
+	}
+	return in.name
+}
+
+func (in *input) isBytecode() bool {
+	return bytes.HasPrefix(in.data, bytecode.Magic[:])
+}
+
+func (e *env) writeJSON(v interface{}) {
+	enc := json.NewEncoder(e.stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+// fail reports err on stderr and returns its exit code
+func (e *env) fail(err error) int {
+	fmt.Fprintln(e.stderr, err)
+	return runner.ExitCode(nil, err)
+}
+
+func runCmd(e *env, in *input) int {
+	opts := runner.Options{Backend: runner.Backend(e.backend)}
+	if e.trace {
+		opts.Trace = e.stderr
+	}
+
+	result, err := runner.Run(in.filename(), in.data, opts)
+	code := runner.ExitCode(result, err)
+
+	if e.json {
+		out := runResultJSON{ExitCode: code}
+		if err != nil {
+			out.Error = err.Error()
+		} else {
+			out.Result = result.Inspect()
+			out.Type = string(result.Type())
+		}
+		e.writeJSON(out)
+		return code
+	}
+
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+	}
+	return code
+}
+
+func replCmd(e *env, _ *input) int {
+	repl.Start(e.stdin, e.stdout, runner.Backend(e.backend))
+	return runner.ExitOK
+}
+
+func tokensCmd(e *env, in *input) int {
+	l := lexer.NewFile(in.filename(), string(in.data))
+	l.SetEmitComments(true)
+
+	var toks []tokenJSON
+	for {
+		tok := l.NextToken()
+		if e.json {
+			toks = append(toks, newTokenJSON(tok))
+		} else {
+			fmt.Fprintf(e.stdout, "%-8s %-10s %q\n", tok.Span.Start, tok.Type, tok.Literal)
+		}
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+
+	if e.json {
+		e.writeJSON(toks)
+	}
+	return runner.ExitOK
+}
+
+func astCmd(e *env, in *input) int {
+	program, err := runner.ParseSource(in.filename(), string(in.data))
+	if err != nil {
+		return e.fail(err)
+	}
+
+	if e.json {
+		e.writeJSON(nodeJSON(program))
+	} else {
+		ast.Fprint(e.stdout, program)
+	}
+	return runner.ExitOK
+}
+
+func disasmCmd(e *env, in *input) int {
+	bc, err := e.bytecode(in)
+	if err != nil {
+		return e.fail(err)
+	}
+	fmt.Fprint(e.stdout, bc.Disassemble())
+	return runner.ExitOK
+}
+
+func buildCmd(e *env, in *input) int {
+	output := e.output
+	if output == "" {
+		if in.name == stdinName {
+			return e.usageError("-o is required when reading from stdin")
+		}
+		output = strings.TrimSuffix(in.name, filepath.Ext(in.name)) + runner.BytecodeExt
+	}
+
+	if in.isBytecode() {
+		return e.usageError(in.name + " is already bytecode")
+	}
+	bc, err := runner.CompileSource(in.filename(), string(in.data))
+	if err != nil {
+		return e.fail(err)
+	}
+
+	if err := runner.WriteBytecode(bc, output); err != nil {
+		return e.fail(err)
+	}
+	fmt.Fprintln(e.stdout, "wrote", output)
+	return runner.ExitOK
+}
+
+func fmtCmd(e *env, in *input) int {
+	// Parse with comments kept so the formatter can put them back
+	l := lexer.NewFile(in.filename(), string(in.data))
+	l.SetEmitComments(true)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if err := p.Diagnostics().Err(); err != nil {
+		return e.fail(&runner.CompileError{Err: fmt.Errorf("parser errors:\n%w", err)})
+	}
+	fmt.Fprint(e.stdout, format.Source(program))
+	return runner.ExitOK
+}
+
+func checkCmd(e *env, in *input) int {
+	p := parser.New(lexer.NewFile(in.filename(), string(in.data)))
+	program := p.ParseProgram()
+	diags := p.Diagnostics()
+
+	// Compiling a tree with syntax errors would only add noise
+	if !diags.HasErrors() {
+		comp := compiler.New()
+		comp.Compile(program)
+		diags = append(diags, comp.Diagnostics()...)
+	}
+
+	code := runner.ExitOK
+	if diags.HasErrors() {
+		code = runner.ExitCompileError
+	}
+
+	if e.json {
+		out := checkJSON{File: in.name, Diagnostics: []diagnosticJSON{}}
+		for _, d := range diags {
+			out.Diagnostics = append(out.Diagnostics, newDiagnosticJSON(d))
+		}
+		e.writeJSON(out)
+		return code
+	}
+
+	for _, d := range diags {
+		fmt.Fprintln(e.stderr, d)
+	}
+	return code
+}
+
+// bytecode compiles in, or decodes it if it is already bytecode
+func (e *env) bytecode(in *input) (*bytecode.Bytecode, error) {
+	if in.isBytecode() {
+		bc, err := bytecode.Decode(bytes.NewReader(in.data))
+		if err != nil {
+			return nil, &runner.CompileError{Err: fmt.Errorf("could not load %s: %w", in.name, err)}
+		}
+		return bc, nil
+	}
+	return runner.CompileSource(in.filename(), string(in.data))
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"reflect"
+
+	"github.com/RavenStorm-bit/toy-compiler/ast"
+	"github.com/RavenStorm-bit/toy-compiler/diagnostic"
+	"github.com/RavenStorm-bit/toy-compiler/token"
+)
+
+type positionJSON struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type spanJSON struct {
+	Start positionJSON `json:"start"`
+	End   positionJSON `json:"end"`
+}
+
+func newSpanJSON(start, end token.Position) spanJSON {
+	return spanJSON{
+		Start: positionJSON{Line: start.Line, Column: start.Column},
+		End:   positionJSON{Line: end.Line, Column: end.Column},
+	}
+}
+
+type tokenJSON struct {
+	Type    token.TokenType `json:"type"`
+	Literal string          `json:"literal"`
+	Span    spanJSON        `json:"span"`
+}
+
+func newTokenJSON(tok token.Token) tokenJSON {
+	return tokenJSON{
+		Type:    tok.Type,
+		Literal: tok.Literal,
+		Span:    newSpanJSON(tok.Span.Start, tok.Span.End),
+	}
+}
+
+type diagnosticJSON struct {
+	Severity string            `json:"severity"`
+	Message  string            `json:"message"`
+	Span     spanJSON          `json:"span"`
+	Expected []token.TokenType `json:"expected,omitempty"`
+	Hint     string            `json:"hint,omitempty"`
+}
+
+func newDiagnosticJSON(d *diagnostic.Diagnostic) diagnosticJSON {
+	return diagnosticJSON{
+		Severity: d.Severity.String(),
+		Message:  d.Message,
+		Span:     newSpanJSON(d.Span.Start, d.Span.End),
+		Expected: d.Expected,
+		Hint:     d.Hint,
+	}
+}
+
+type checkJSON struct {
+	File        string           `json:"file"`
+	Diagnostics []diagnosticJSON `json:"diagnostics"`
+}
+
+type runResultJSON struct {
+	Result   string `json:"result,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+var nodeType = reflect.TypeOf((*ast.Node)(nil)).Elem()
+
+// nodeJSON converts a syntax tree into maps that encode as JSON. Every
+// node has its type under "node" and its "span"; the other keys are the
+// node's exported fields, minus the tokens it was parsed from.
+func nodeJSON(n ast.Node) interface{} {
+	v := reflect.ValueOf(n)
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return nil
+	}
+
+	out := map[string]interface{}{
+		"node": v.Elem().Type().Name(),
+		"span": newSpanJSON(n.Pos(), n.End()),
+	}
+	ast.EachField(n, func(name string, f reflect.Value) {
+		if f.Kind() == reflect.Ptr && f.IsNil() && !f.Type().Implements(nodeType) {
+			return // an unset optional value, not a missing child node
+		}
+		out[name] = fieldJSON(f)
+	})
+	return out
+}
+
+func fieldJSON(f reflect.Value) interface{} {
+	switch {
+	case f.Type().Implements(nodeType):
+		if f.IsNil() {
+			return nil
+		}
+		return nodeJSON(f.Interface().(ast.Node))
+	case f.Kind() == reflect.Slice && f.Type().Elem().Implements(nodeType):
+		list := make([]interface{}, f.Len())
+		for i := range list {
+			list[i] = fieldJSON(f.Index(i))
+		}
+		return list
+	default:
+		return f.Interface()
+	}
+}
@@ -1,34 +1,844 @@
 package compiler
 
 import (
+	"fmt"
+
 	"github.com/RavenStorm-bit/toy-compiler/ast"
 	"github.com/RavenStorm-bit/toy-compiler/bytecode"
+	"github.com/RavenStorm-bit/toy-compiler/diagnostic"
+	"github.com/RavenStorm-bit/toy-compiler/object"
+	"github.com/RavenStorm-bit/toy-compiler/stdlib"
+	"github.com/RavenStorm-bit/toy-compiler/token"
 )
 
+// EmittedInstruction records an instruction's opcode and where it starts
+type EmittedInstruction struct {
+	Opcode   bytecode.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function being compiled
+type CompilationScope struct {
+	instructions        bytecode.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	// positions maps the instructions to the nodes they were compiled
+	// from, so the VM can say where an error happened
+	positions object.Positions
+
+	// loops are the loops being compiled in this function, innermost last
+	loops []*loopJumps
+}
+
+// loopJumps collects the break and continue jumps of a loop, which are
+// patched once the loop has been compiled
+type loopJumps struct {
+	breaks    []int
+	continues []int
+}
+
 // Compiler traverses the AST and generates bytecode
 type Compiler struct {
-	instructions bytecode.Instructions
-	constants    []interface{}
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	diagnostics diagnostic.List
+
+	// node is the innermost node being compiled, which operand limit
+	// errors are reported at; limits holds the limits already reported
+	node   ast.Node
+	limits map[string]bool
+
+	// declared holds the names the program's top-level lets define, which
+	// function bodies may use before the let that defines them
+	declared map[string]bool
 }
 
 // New creates a new Compiler instance
 func New() *Compiler {
-	return &Compiler{
+	return NewWithState(NewGlobalSymbolTable(), []object.Object{})
+}
+
+// NewWithState creates a Compiler that continues where an earlier one
+// left off: it resolves globals through symbolTable and appends to
+// constants. The REPL compiles each input this way so later inputs see
+// the definitions of earlier ones.
+func NewWithState(symbolTable *SymbolTable, constants []object.Object) *Compiler {
+	mainScope := CompilationScope{
 		instructions: bytecode.Instructions{},
-		constants:    []interface{}{},
 	}
+
+	return &Compiler{
+		constants:   constants,
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewGlobalSymbolTable creates a top-level symbol table with the builtins
+// defined
+func NewGlobalSymbolTable() *SymbolTable {
+	symbolTable := NewSymbolTable()
+	for i, b := range stdlib.Builtins {
+		symbolTable.DefineBuiltin(i, b.Name)
+	}
+	return symbolTable
 }
 
-// Compile generates bytecode from an AST node
+// Compile generates bytecode from an AST node. Problems such as undefined
+// variables do not stop compilation; they are collected and returned
+// together as a diagnostic.List.
 func (c *Compiler) Compile(node ast.Node) error {
-	// TODO: Implement AST traversal and bytecode generation
-	return nil
+	c.diagnostics = nil
+	c.limits = map[string]bool{}
+	c.compile(node)
+	return c.diagnostics.Err()
+}
+
+// Diagnostics returns the problems reported by the last call to Compile
+func (c *Compiler) Diagnostics() diagnostic.List {
+	return c.diagnostics
+}
+
+func (c *Compiler) compile(node ast.Node) {
+	if node != nil {
+		outer := c.node
+		c.node = node
+		defer func() { c.node = outer }()
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		c.declared = topLevelNames(node)
+		for _, s := range node.Statements {
+			c.compile(s)
+		}
+		// The VM takes the program's result from the last top-level pop.
+		// When the last statement is not an expression, that pop may belong
+		// to an earlier statement or a loop body, so pop a null instead.
+		if n := len(node.Statements); n > 0 && !isExpression(node.Statements[n-1]) {
+			c.emit(bytecode.OpNull)
+			c.emit(bytecode.OpPop)
+		}
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			c.compile(s)
+		}
+
+	case *ast.ExpressionStatement:
+		if node.Expression == nil {
+			return
+		}
+		c.compile(node.Expression)
+		c.emit(bytecode.OpPop)
+
+	case *ast.LetStatement:
+		// The value is compiled before the name is defined, so it cannot
+		// read the variable it initializes. A function literal refers to
+		// itself through its Name instead.
+		c.compile(node.Value)
+		symbol := c.symbolTable.Define(node.Name.Value)
+		c.storeSymbol(node, symbol)
+
+	case *ast.AssignmentStatement:
+		c.compileAssignment(node)
+
+	case *ast.ReturnStatement:
+		if node.ReturnValue == nil {
+			c.emit(bytecode.OpReturn)
+			return
+		}
+		c.compile(node.ReturnValue)
+		c.emit(bytecode.OpReturnValue)
+
+	case *ast.WhileStatement:
+		loopStart := len(c.currentInstructions())
+
+		c.compile(node.Condition)
+		jumpNotTruePos := c.emit(bytecode.OpJumpNotTrue, 9999)
+
+		c.enterLoop()
+		c.compile(node.Body)
+		c.emit(bytecode.OpJump, loopStart)
+
+		c.changeOperand(jumpNotTruePos, len(c.currentInstructions()))
+		c.leaveLoop(loopStart)
+
+	case *ast.ForStatement:
+		if node.Init != nil {
+			c.compile(node.Init)
+		}
+		loopStart := len(c.currentInstructions())
+
+		jumpNotTruePos := -1
+		if node.Condition != nil {
+			c.compile(node.Condition)
+			jumpNotTruePos = c.emit(bytecode.OpJumpNotTrue, 9999)
+		}
+
+		c.enterLoop()
+		c.compile(node.Body)
+
+		// continue runs the post statement before testing the condition
+		postStart := len(c.currentInstructions())
+		if node.Post != nil {
+			c.compile(node.Post)
+		}
+		c.emit(bytecode.OpJump, loopStart)
+
+		if jumpNotTruePos >= 0 {
+			c.changeOperand(jumpNotTruePos, len(c.currentInstructions()))
+		}
+		c.leaveLoop(postStart)
+
+	case *ast.ForInStatement:
+		c.compileForIn(node)
+
+	case *ast.BreakStatement, *ast.ContinueStatement:
+		loops := c.scopes[c.scopeIndex].loops
+		if len(loops) == 0 {
+			// The parser rejects these, but not every tree comes from it
+			c.errorf(node, "%s outside a loop", node.TokenLiteral())
+			return
+		}
+
+		loop := loops[len(loops)-1]
+		pos := c.emit(bytecode.OpJump, 9999)
+		if _, ok := node.(*ast.BreakStatement); ok {
+			loop.breaks = append(loop.breaks, pos)
+		} else {
+			loop.continues = append(loop.continues, pos)
+		}
+
+	case *ast.IfExpression:
+		// if is an expression, so each branch leaves one value behind;
+		// a missing else yields null.
+		c.compile(node.Condition)
+		jumpNotTruePos := c.emit(bytecode.OpJumpNotTrue, 9999)
+
+		c.compileBlockValue(node.Consequence)
+		jumpPos := c.emit(bytecode.OpJump, 9999)
+		c.changeOperand(jumpNotTruePos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(bytecode.OpNull)
+		} else {
+			c.compileBlockValue(node.Alternative)
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+
+		c.compile(node.Body)
+
+		// A trailing expression is the function's result
+		if endsWithExpression(node.Body) && c.lastInstructionIs(bytecode.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(bytecode.OpReturnValue) {
+			c.emit(bytecode.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.NumDefinitions()
+		instructions, positions := c.leaveScope()
+
+		// Push the cells of the captured variables in the enclosing scope;
+		// OpClosure collects them into the new closure, which then shares
+		// the variables with that scope.
+		for _, s := range freeSymbols {
+			c.captureSymbol(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Source:        object.FunctionSource(node.Parameters, node.Body),
+			Positions:     positions,
+		}
+		c.emit(bytecode.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+
+	case *ast.CallExpression:
+		c.compile(node.Function)
+		for _, a := range node.Arguments {
+			c.compile(a)
+		}
+		c.emit(bytecode.OpCall, len(node.Arguments))
+
+	case *ast.PrefixExpression:
+		c.compile(node.Right)
+
+		switch node.Operator {
+		case "-":
+			c.emit(bytecode.OpMinus)
+		case "!":
+			c.emit(bytecode.OpBang)
+		default:
+			c.errorfAt(node.Token.Span, "unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			c.compileLogical(node)
+			return
+		}
+
+		c.compile(node.Left)
+		c.compile(node.Right)
+
+		if op, ok := arithmeticOpcodes[node.Operator]; ok {
+			c.emit(op)
+			return
+		}
+
+		switch node.Operator {
+		case ">":
+			c.emit(bytecode.OpGreaterThan)
+		case "<":
+			c.emit(bytecode.OpLessThan)
+		case ">=":
+			c.emit(bytecode.OpGreaterEqual)
+		case "<=":
+			c.emit(bytecode.OpLessEqual)
+		case "==":
+			c.emit(bytecode.OpEqual)
+		case "!=":
+			c.emit(bytecode.OpNotEqual)
+		default:
+			c.errorfAt(node.Token.Span, "unknown operator %s", node.Operator)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			c.compile(e)
+		}
+		c.emit(bytecode.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		for i, key := range node.Keys {
+			c.compile(key)
+			c.compile(node.Values[i])
+		}
+		c.emit(bytecode.OpHash, len(node.Keys)*2)
+
+	case *ast.IndexExpression:
+		c.compile(node.Left)
+		c.compile(node.Index)
+		c.emit(bytecode.OpIndex)
+
+	case *ast.MemberExpression:
+		c.compile(node.Object)
+		c.emitMemberKey(node.Property)
+		c.emit(bytecode.OpIndex)
+
+	case *ast.Identifier:
+		symbol, ok := c.resolve(node.Value)
+		if !ok {
+			c.errorf(node, "undefined variable %s", node.Value)
+			return
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.IntegerLiteral:
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = &object.BigInt{Value: node.Big}
+		}
+		c.emit(bytecode.OpConstant, c.addConstant(integer))
+
+	case *ast.FloatLiteral:
+		f := &object.Float{Value: node.Value}
+		c.emit(bytecode.OpConstant, c.addConstant(f))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(bytecode.OpConstant, c.addConstant(str))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(bytecode.OpTrue)
+		} else {
+			c.emit(bytecode.OpFalse)
+		}
+
+	case nil:
+		// Only reachable for trees the parser rejected
+
+	default:
+		c.errorf(node, "cannot compile node of type %T", node)
+	}
 }
 
 // Bytecode returns the compiled bytecode
 func (c *Compiler) Bytecode() *bytecode.Bytecode {
 	return &bytecode.Bytecode{
-		Instructions: c.instructions,
+		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Positions:    c.scopes[c.scopeIndex].positions,
+	}
+}
+
+// resolve looks name up in the symbol table. Inside a function, a name
+// that a later top-level let defines resolves to its global, which is
+// defined now so the let reuses the slot; a function body only runs once
+// it is called, by which time the let has usually run.
+func (c *Compiler) resolve(name string) (Symbol, bool) {
+	symbol, ok := c.symbolTable.Resolve(name)
+	if ok || c.scopeIndex == 0 || !c.declared[name] {
+		return symbol, ok
+	}
+
+	globals := c.symbolTable
+	for globals.Outer != nil {
+		globals = globals.Outer
+	}
+	return globals.Define(name), true
+}
+
+// topLevelNames returns the names the lets of program define at the top
+// level, including those in the blocks of top-level statements but not
+// in function bodies
+func topLevelNames(program *ast.Program) map[string]bool {
+	names := map[string]bool{}
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			names[n.Name.Value] = true
+		case *ast.ForInStatement:
+			for _, v := range n.Variables {
+				names[v.Value] = true
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// compileLogical compiles && and || so the right operand only runs when
+// the left one does not decide the result. Both produce a boolean:
+//
+//	a && b:  a; JumpNotTrue F; b; JumpNotTrue F; True; Jump E; F: False; E:
+//	a || b:  a; Bang; JumpNotTrue T; b; Bang; JumpNotTrue T; False; Jump E; T: True; E:
+func (c *Compiler) compileLogical(node *ast.InfixExpression) {
+	decided, other := bytecode.OpFalse, bytecode.OpTrue
+	if node.Operator == "||" {
+		decided, other = bytecode.OpTrue, bytecode.OpFalse
+	}
+
+	var jumps []int
+	for _, operand := range []ast.Expression{node.Left, node.Right} {
+		c.compile(operand)
+		if node.Operator == "||" {
+			c.emit(bytecode.OpBang)
+		}
+		jumps = append(jumps, c.emit(bytecode.OpJumpNotTrue, 9999))
+	}
+
+	c.emit(other)
+	jumpEnd := c.emit(bytecode.OpJump, 9999)
+	for _, pos := range jumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	c.emit(decided)
+	c.changeOperand(jumpEnd, len(c.currentInstructions()))
+}
+
+var arithmeticOpcodes = map[string]bytecode.Opcode{
+	"+": bytecode.OpAdd,
+	"-": bytecode.OpSub,
+	"*": bytecode.OpMul,
+	"/": bytecode.OpDiv,
+	"%": bytecode.OpMod,
+}
+
+// compileAssignment compiles an assignment to a variable, element or
+// member. The target's subexpressions are evaluated once, before the
+// value; a compound assignment reads the current value in between:
+//
+//	a[i] += v:  a; i; Dup2; Index; v; Add; SetIndex
+func (c *Compiler) compileAssignment(node *ast.AssignmentStatement) {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.resolve(target.Value)
+		if !ok {
+			c.errorf(target, "assignment to undeclared variable %s", target.Value)
+			return
+		}
+		if node.BinaryOperator() != "" {
+			c.loadSymbol(symbol)
+		}
+		c.compileAssignedValue(node)
+		c.storeSymbol(target, symbol)
+
+	case *ast.IndexExpression:
+		c.compile(target.Left)
+		c.compile(target.Index)
+		c.compileElementAssignment(node)
+
+	case *ast.MemberExpression:
+		c.compile(target.Object)
+		c.emitMemberKey(target.Property)
+		c.compileElementAssignment(node)
+
+	default:
+		c.errorf(node.Target, "cannot assign to %s", node.Target.String())
+	}
+}
+
+// compileElementAssignment finishes an assignment to an element whose
+// container and key are already on the stack
+func (c *Compiler) compileElementAssignment(node *ast.AssignmentStatement) {
+	if node.BinaryOperator() != "" {
+		c.emit(bytecode.OpDup2)
+		c.emit(bytecode.OpIndex)
+	}
+	c.compileAssignedValue(node)
+	c.emit(bytecode.OpSetIndex)
+}
+
+// compileAssignedValue pushes the value being assigned, combining it with
+// the target's current value below it for a compound assignment. ++ and
+// -- have an implicit value of 1.
+func (c *Compiler) compileAssignedValue(node *ast.AssignmentStatement) {
+	if node.Value != nil {
+		c.compile(node.Value)
+	} else {
+		c.emit(bytecode.OpConstant, c.addConstant(&object.Integer{Value: 1}))
+	}
+
+	operator := node.BinaryOperator()
+	if operator == "" {
+		return
+	}
+	op, ok := arithmeticOpcodes[operator]
+	if !ok {
+		c.errorfAt(node.Token.Span, "unknown operator %s", node.Operator)
+		return
+	}
+	c.emit(op)
+}
+
+// emitMemberKey pushes the key a.b looks up, the string "b"
+func (c *Compiler) emitMemberKey(property *ast.Identifier) {
+	c.emit(bytecode.OpConstant, c.addConstant(&object.String{Value: property.Value}))
+}
+
+// compileBlockValue compiles a block that produces a value: that of its
+// last statement if it is an expression, and null otherwise
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) {
+	c.compile(block)
+	if endsWithExpression(block) && c.lastInstructionIs(bytecode.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(bytecode.OpNull)
+	}
+}
+
+func endsWithExpression(block *ast.BlockStatement) bool {
+	if len(block.Statements) == 0 {
+		return false
+	}
+	return isExpression(block.Statements[len(block.Statements)-1])
+}
+
+// isExpression reports whether a statement leaves a value to pop
+func isExpression(s ast.Statement) bool {
+	stmt, ok := s.(*ast.ExpressionStatement)
+	return ok && stmt.Expression != nil
+}
+
+// compileForIn compiles a for-in loop. The iterator lives in a hidden
+// variable named after the loop's depth, which no identifier can refer to:
+//
+//	iterable; Iter; Set it; S: Get it; IterNext E, n; Set vars; body; Jump S; E:
+func (c *Compiler) compileForIn(node *ast.ForInStatement) {
+	c.compile(node.Iterable)
+	c.emitAt(node.Iterable, bytecode.OpIter)
+	depth := len(c.scopes[c.scopeIndex].loops)
+	iterator := c.symbolTable.Define(fmt.Sprintf("for.%d", depth))
+	c.storeSymbol(node, iterator)
+
+	loopStart := len(c.currentInstructions())
+	c.loadSymbol(iterator)
+	iterNextPos := c.emit(bytecode.OpIterNext, 9999, len(node.Variables))
+
+	// The last variable is on top of the stack
+	for i := len(node.Variables) - 1; i >= 0; i-- {
+		v := node.Variables[i]
+		c.storeSymbol(v, c.symbolTable.Define(v.Value))
+	}
+
+	c.enterLoop()
+	c.compile(node.Body)
+	c.emit(bytecode.OpJump, loopStart)
+
+	c.changeOperand(iterNextPos, len(c.currentInstructions()))
+	c.leaveLoop(loopStart)
+}
+
+// enterLoop starts collecting the break and continue jumps of a new
+// innermost loop
+func (c *Compiler) enterLoop() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loopJumps{})
+}
+
+// leaveLoop patches the innermost loop's continue jumps to go to
+// continueTarget and its break jumps to go to the current end of the
+// instructions, just past the loop
+func (c *Compiler) leaveLoop(continueTarget int) {
+	scope := &c.scopes[c.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range loop.continues {
+		c.changeOperand(pos, continueTarget)
+	}
+	for _, pos := range loop.breaks {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(bytecode.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(bytecode.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(bytecode.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(bytecode.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(bytecode.OpCurrentClosure)
+	}
+}
+
+// captureSymbol pushes the cell holding s, which a nested function
+// captures. Only locals and free variables are captured.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(bytecode.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(bytecode.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+// storeSymbol pops the top of stack into s
+func (c *Compiler) storeSymbol(node ast.Node, s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(bytecode.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(bytecode.OpSetLocal, s.Index)
+	case FreeScope:
+		if c.symbolTable.Origin(s).Scope == FunctionScope {
+			c.errorf(node, "cannot assign to %s inside its own definition", s.Name)
+			return
+		}
+		c.emit(bytecode.OpSetFree, s.Index)
+	case BuiltinScope:
+		c.errorf(node, "cannot assign to builtin %s", s.Name)
+	case FunctionScope:
+		c.errorf(node, "cannot assign to %s inside its own definition", s.Name)
 	}
 }
+
+func (c *Compiler) errorf(node ast.Node, format string, args ...interface{}) {
+	c.errorfAt(token.Span{Start: node.Pos(), End: node.End()}, format, args...)
+}
+
+func (c *Compiler) errorfAt(span token.Span, format string, args ...interface{}) {
+	c.diagnostics = append(c.diagnostics, diagnostic.Errorf(span, format, args...))
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op bytecode.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := bytecode.Make(op, operands...)
+	pos := c.addInstruction(ins)
+	c.setLastInstruction(op, pos)
+	return pos
+}
+
+// emitAt emits an instruction whose errors are reported at node rather
+// than the node being compiled
+func (c *Compiler) emitAt(node ast.Node, op bytecode.Opcode, operands ...int) int {
+	outer := c.node
+	c.node = node
+	defer func() { c.node = outer }()
+	return c.emit(op, operands...)
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	c.addPosition(posNewInstruction)
+	return posNewInstruction
+}
+
+// addPosition records that the instruction at offset was compiled from
+// the current node. A run of instructions from the same position shares
+// one entry.
+func (c *Compiler) addPosition(offset int) {
+	if c.node == nil || !c.node.Pos().IsValid() {
+		return
+	}
+	scope := &c.scopes[c.scopeIndex]
+	pos := c.node.Pos()
+	if n := len(scope.positions); n > 0 && scope.positions[n-1].Pos == pos {
+		return
+	}
+	scope.positions = append(scope.positions, object.InstructionPosition{Offset: offset, Pos: pos})
+}
+
+func (c *Compiler) setLastInstruction(op bytecode.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op bytecode.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+// removeLastPop drops the OpPop just emitted, leaving its value on the
+// stack. Jumps that targeted it now target whatever is emitted next.
+func (c *Compiler) removeLastPop() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions = scope.instructions[:scope.lastInstruction.Position]
+	scope.lastInstruction = scope.previousInstruction
+
+	for n := len(scope.positions); n > 0 && scope.positions[n-1].Offset >= len(scope.instructions); n-- {
+		scope.positions = scope.positions[:n-1]
+	}
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	scope := &c.scopes[c.scopeIndex]
+	c.replaceInstruction(scope.lastInstruction.Position, bytecode.Make(bytecode.OpReturnValue))
+	scope.lastInstruction.Opcode = bytecode.OpReturnValue
+}
+
+// changeOperand back-patches the first operand of the instruction at
+// opPos, keeping any others
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	ins := c.currentInstructions()
+	op := bytecode.Opcode(ins[opPos])
+	def, _ := bytecode.Lookup(byte(op))
+	operands, _ := bytecode.ReadOperands(def, ins[opPos+1:])
+	operands[0] = operand
+	c.checkOperands(op, operands)
+	c.replaceInstruction(opPos, bytecode.Make(op, operands...))
+}
+
+// checkOperands reports an operand too large for its width, which Make
+// would silently truncate. Each limit is reported once.
+func (c *Compiler) checkOperands(op bytecode.Opcode, operands []int) {
+	def, err := bytecode.Lookup(byte(op))
+	if err != nil {
+		return
+	}
+	for i, operand := range operands {
+		max := bytecode.MaxOperand(def.OperandWidths[i])
+		if operand <= max {
+			continue
+		}
+		msg := operandLimit(def, op, i, max)
+		if c.limits[msg] {
+			continue
+		}
+		c.limits[msg] = true
+		if c.node == nil {
+			c.errorfAt(token.Span{}, "%s", msg)
+		} else {
+			c.errorf(c.node, "%s", msg)
+		}
+	}
+}
+
+// operandLimit describes the limit behind operand i of op, whose largest
+// value is max
+func operandLimit(def *bytecode.Definition, op bytecode.Opcode, i, max int) string {
+	switch {
+	case op == bytecode.OpConstant, op == bytecode.OpClosure && i == 0:
+		return fmt.Sprintf("too many constants (at most %d)", max+1)
+	case op == bytecode.OpClosure, op == bytecode.OpGetFree, op == bytecode.OpSetFree,
+		op == bytecode.OpCaptureFree:
+		return fmt.Sprintf("too many captured variables in one function (at most %d)", max+1)
+	case op == bytecode.OpGetGlobal, op == bytecode.OpSetGlobal:
+		return fmt.Sprintf("too many global variables (at most %d)", max+1)
+	case op == bytecode.OpGetLocal, op == bytecode.OpSetLocal, op == bytecode.OpCaptureLocal:
+		return fmt.Sprintf("too many local variables in one function (at most %d)", max+1)
+	case op == bytecode.OpCall:
+		return fmt.Sprintf("too many arguments in one call (at most %d)", max)
+	case op == bytecode.OpArray:
+		return fmt.Sprintf("too many elements in one array literal (at most %d)", max)
+	case op == bytecode.OpHash:
+		return fmt.Sprintf("too many entries in one hash literal (at most %d)", max/2)
+	case op == bytecode.OpJump, op == bytecode.OpJumpNotTrue, op == bytecode.OpIterNext:
+		return fmt.Sprintf("function too long: jumps reach at most %d bytes of bytecode", max)
+	}
+	return fmt.Sprintf("operand %d of %s exceeds %d", i, def.Name, max)
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) currentInstructions() bytecode.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions: bytecode.Instructions{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() (bytecode.Instructions, object.Positions) {
+	scope := c.scopes[c.scopeIndex]
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return scope.instructions, scope.positions
+}
@@ -0,0 +1,157 @@
+package compiler
+
+import "sort"
+
+// SymbolScope says where a resolved name's value is stored at run time
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+// Symbol is a name bound in some scope, with its slot index there
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable maps names to symbols for one function, or for the top
+// level when Outer is nil
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	// FreeSymbols are the enclosing symbols captured by this function, in
+	// the order the closure stores them
+	FreeSymbols []Symbol
+
+	store          map[string]Symbol
+	numDefinitions int
+}
+
+// NewSymbolTable creates a top-level symbol table
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		FreeSymbols: []Symbol{},
+		store:       map[string]Symbol{},
+	}
+}
+
+// NewEnclosedSymbolTable creates a symbol table for a function nested
+// inside outer
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Copy returns a copy of the table that can be defined into without
+// affecting s. Free symbols are shared, so it is meant for a top-level
+// table.
+func (s *SymbolTable) Copy() *SymbolTable {
+	c := &SymbolTable{
+		Outer:          s.Outer,
+		FreeSymbols:    s.FreeSymbols,
+		store:          make(map[string]Symbol, len(s.store)),
+		numDefinitions: s.numDefinitions,
+	}
+	for name, symbol := range s.store {
+		c.store[name] = symbol
+	}
+	return c
+}
+
+// Define binds name to the next free slot. Defining a name that already
+// has a slot in this table reuses it, so indices stay stable when a
+// variable is redeclared.
+func (s *SymbolTable) Define(name string) Symbol {
+	if existing, ok := s.store[name]; ok {
+		if existing.Scope == GlobalScope || existing.Scope == LocalScope {
+			return existing
+		}
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// DefineBuiltin binds name to the builtin at index
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Scope: BuiltinScope, Index: index}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName binds the name a function can use to refer to itself
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Scope: FunctionScope, Index: 0}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Resolve looks name up in this table and then the enclosing ones. A
+// local of an enclosing function is turned into a free symbol of this one.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok {
+		return symbol, ok
+	}
+
+	if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+
+	return s.defineFree(symbol), true
+}
+
+// Origin returns the symbol a free symbol of this table was captured
+// from, following it out through the enclosing functions to the one that
+// defined it. Other symbols are returned as they are.
+func (s *SymbolTable) Origin(symbol Symbol) Symbol {
+	for table := s; symbol.Scope == FreeScope; table = table.Outer {
+		symbol = table.FreeSymbols[symbol.Index]
+	}
+	return symbol
+}
+
+// Names returns the names Define has bound in this table, sorted
+func (s *SymbolTable) Names() []string {
+	var names []string
+	for name, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NumDefinitions returns how many slots Define has handed out
+func (s *SymbolTable) NumDefinitions() int {
+	return s.numDefinitions
+}
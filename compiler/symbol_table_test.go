@@ -0,0 +1,98 @@
+package compiler
+
+import "testing"
+
+func TestDefineAndResolve(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	b := global.Define("b")
+
+	local := NewEnclosedSymbolTable(global)
+	c := local.Define("c")
+
+	nested := NewEnclosedSymbolTable(local)
+	d := nested.Define("d")
+
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: GlobalScope, Index: 1},
+		"c": {Name: "c", Scope: LocalScope, Index: 0},
+		"d": {Name: "d", Scope: LocalScope, Index: 0},
+	}
+	for _, got := range []Symbol{a, b, c, d} {
+		if got != expected[got.Name] {
+			t.Errorf("Define(%s) wrong. want=%+v, got=%+v", got.Name, expected[got.Name], got)
+		}
+	}
+
+	if again := global.Define("a"); again != a {
+		t.Errorf("redefining a changed its symbol. want=%+v, got=%+v", a, again)
+	}
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{nested, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{nested, "c", Symbol{Name: "c", Scope: FreeScope, Index: 0}},
+		{nested, "d", Symbol{Name: "d", Scope: LocalScope, Index: 0}},
+		{local, "c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.table.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("Resolve(%s) wrong. want=%+v, got=%+v", tt.name, tt.expected, got)
+		}
+	}
+
+	if len(nested.FreeSymbols) != 1 || nested.FreeSymbols[0] != c {
+		t.Errorf("wrong free symbols. got=%+v", nested.FreeSymbols)
+	}
+
+	if _, ok := nested.Resolve("missing"); ok {
+		t.Errorf("undefined name resolved")
+	}
+}
+
+func TestResolveBuiltinsAndFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+
+	fn := NewEnclosedSymbolTable(global)
+	fn.DefineFunctionName("fact")
+
+	inner := NewEnclosedSymbolTable(fn)
+
+	if s, _ := inner.Resolve("len"); s.Scope != BuiltinScope {
+		t.Errorf("len not resolved as builtin. got=%+v", s)
+	}
+	if s, _ := fn.Resolve("fact"); s.Scope != FunctionScope {
+		t.Errorf("fact not resolved as function name. got=%+v", s)
+	}
+	if s, _ := inner.Resolve("fact"); s.Scope != FreeScope {
+		t.Errorf("fact not captured by inner function. got=%+v", s)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+
+	c := global.Copy()
+	b := c.Define("b")
+	if b.Index != 1 {
+		t.Errorf("copy did not continue the slot numbering. got=%+v", b)
+	}
+	if s, ok := c.Resolve("a"); !ok || s != a {
+		t.Errorf("copy lost a. got=%+v", s)
+	}
+	if _, ok := global.Resolve("b"); ok || global.NumDefinitions() != 1 {
+		t.Errorf("defining in the copy changed the original")
+	}
+}
@@ -0,0 +1,90 @@
+package diagnostic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RavenStorm-bit/toy-compiler/token"
+)
+
+// Severity classifies how serious a diagnostic is
+type Severity int
+
+const (
+	// Error marks a problem that stops the program from being run
+	Error Severity = iota
+	// Warning marks suspicious code that is still accepted
+	Warning
+	// Note gives additional information about another diagnostic
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Diagnostic is a message about a location in the source
+type Diagnostic struct {
+	Severity Severity
+	Span     token.Span
+	Message  string
+	Expected []token.TokenType // tokens that would have been accepted, if any
+	Hint     string            // optional suggestion for fixing the problem
+}
+
+// Errorf creates an error diagnostic for span
+func Errorf(span token.Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Span:     span,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// Error formats the diagnostic as "pos: severity: message", followed by
+// the hint when there is one
+func (d *Diagnostic) Error() string {
+	msg := fmt.Sprintf("%s: %s: %s", d.Span.Start, d.Severity, d.Message)
+	if d.Hint != "" {
+		msg += " (hint: " + d.Hint + ")"
+	}
+	return msg
+}
+
+// List is a list of diagnostics that can be returned as a single error
+type List []*Diagnostic
+
+// HasErrors reports whether any diagnostic in the list is an error
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the list as an error, or nil when it contains no errors
+func (l List) Err() error {
+	if !l.HasErrors() {
+		return nil
+	}
+	return l
+}
+
+func (l List) Error() string {
+	msgs := make([]string, len(l))
+	for i, d := range l {
+		msgs[i] = d.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -2,36 +2,678 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+
 	"github.com/RavenStorm-bit/toy-compiler/ast"
+	"github.com/RavenStorm-bit/toy-compiler/object"
+	"github.com/RavenStorm-bit/toy-compiler/stdlib"
+)
+
+// MaxCallDepth is how deeply function calls can nest before evaluation
+// stops with a stack overflow error, as the VM does when it runs out of
+// frames
+const MaxCallDepth = 1024
+
+var (
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
-func Eval(node ast.Node) int64 {
+// Eval evaluates node in env and returns its value. Runtime errors are
+// returned as *object.Error values rather than Go errors.
+func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
+	// Statements
+	case *ast.Program:
+		return evalProgram(node, env)
+
+	case *ast.BlockStatement:
+		return evalBlockStatement(node, env)
+
+	case *ast.ExpressionStatement:
+		return Eval(node.Expression, env)
+
+	case *ast.LetStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		env.Set(node.Name.Value, val)
+		return NULL
+
+	case *ast.AssignmentStatement:
+		return evalAssignment(node, env)
+
+	case *ast.ReturnStatement:
+		if node.ReturnValue == nil {
+			return &object.ReturnValue{Value: NULL}
+		}
+		val := Eval(node.ReturnValue, env)
+		if isError(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+
+	case *ast.BreakStatement:
+		return &object.LoopControl{}
+
+	case *ast.ContinueStatement:
+		return &object.LoopControl{Continue: true}
+
+	// Expressions
 	case *ast.IntegerLiteral:
-		return node.Value
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
+		return &object.Integer{Value: node.Value}
+
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
+
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(node.Value)
+
+	case *ast.Identifier:
+		return evalIdentifier(node, env)
+
+	case *ast.PrefixExpression:
+		right := Eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
+		return evalPrefixExpression(node, node.Operator, right)
+
 	case *ast.InfixExpression:
-		left := Eval(node.Left)
-		right := Eval(node.Right)
-		return evalInfixExpression(node.Operator, left, right)
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		right := Eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
+		return evalInfixExpression(node, node.Operator, left, right)
+
+	case *ast.IfExpression:
+		return evalIfExpression(node, env)
+
+	case *ast.ArrayLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &object.Array{Elements: elements}
+
+	case *ast.HashLiteral:
+		return evalHashLiteral(node, env)
+
+	case *ast.IndexExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index
+		}
+		return evalIndexExpression(node, left, index)
+
+	case *ast.MemberExpression:
+		left := Eval(node.Object, env)
+		if isError(left) {
+			return left
+		}
+		return evalIndexExpression(node, left, memberKey(node.Property))
+
+	case *ast.FunctionLiteral:
+		return &object.Function{Parameters: node.Parameters, Body: node.Body, Env: env}
+
+	case *ast.CallExpression:
+		function := Eval(node.Function, env)
+		if isError(function) {
+			return function
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		return applyFunction(node, function, args, env)
+	}
+
+	return newError(node, "cannot evaluate node of type %T", node)
+}
+
+func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	var result object.Object = NULL
+
+	for _, statement := range program.Statements {
+		result = Eval(statement, env)
+
+		switch result := result.(type) {
+		case *object.ReturnValue:
+			return result.Value
+		case *object.Error:
+			return result
+		}
+	}
+
+	return result
+}
+
+// evalBlockStatement evaluates the statements of a block in the enclosing
+// environment; only function calls introduce a new scope. Return values,
+// errors, breaks and continues are passed up unwrapped so they keep
+// unwinding.
+func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	var result object.Object = NULL
+
+	for _, statement := range block.Statements {
+		result = Eval(statement, env)
+
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.LOOP_CONTROL_OBJ {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		if result, stop := evalLoopBody(ws.Body, env); stop {
+			return result
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	if fs.Init != nil {
+		if init := Eval(fs.Init, env); isError(init) {
+			return init
+		}
+	}
+
+	for {
+		if fs.Condition != nil {
+			condition := Eval(fs.Condition, env)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				return NULL
+			}
+		}
+
+		if result, stop := evalLoopBody(fs.Body, env); stop {
+			return result
+		}
+
+		if fs.Post != nil {
+			if post := Eval(fs.Post, env); isError(post) {
+				return post
+			}
+		}
 	}
-	return 0
 }
 
-func evalInfixExpression(operator string, left, right int64) int64 {
+func evalForInStatement(fs *ast.ForInStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	iterator, err := object.NewIterator(iterable)
+	if err != nil {
+		return newError(fs.Iterable, "%s", err)
+	}
+
+	for {
+		vars, ok := iterator.Next(len(fs.Variables))
+		if !ok {
+			return NULL
+		}
+		for i, v := range fs.Variables {
+			env.Set(v.Value, vars[i])
+		}
+
+		if result, stop := evalLoopBody(fs.Body, env); stop {
+			return result
+		}
+	}
+}
+
+// evalLoopBody runs one iteration of a loop body and reports whether the
+// loop should stop, along with what the loop then evaluates to: null
+// after a break, or a return value or error that keeps unwinding.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	switch result := Eval(body, env).(type) {
+	case *object.ReturnValue, *object.Error:
+		return result, true
+	case *object.LoopControl:
+		if !result.Continue {
+			return NULL, true
+		}
+	}
+	return nil, false
+}
+
+func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := Eval(ie.Condition, env)
+	if isError(condition) {
+		return condition
+	}
+
+	if isTruthy(condition) {
+		return Eval(ie.Consequence, env)
+	} else if ie.Alternative != nil {
+		return Eval(ie.Alternative, env)
+	}
+	return NULL
+}
+
+func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if val, ok := env.Get(node.Value); ok {
+		return val
+	}
+
+	if builtin, ok := stdlib.GetBuiltin(node.Value); ok {
+		return builtin
+	}
+
+	return newError(node, "undefined variable %s", node.Value)
+}
+
+func evalPrefixExpression(node ast.Node, operator string, right object.Object) object.Object {
+	switch operator {
+	case "!":
+		return nativeBoolToBooleanObject(!isTruthy(right))
+	case "-":
+		switch right := right.(type) {
+		case *object.Integer, *object.BigInt:
+			return object.NegateInteger(right)
+		case *object.Float:
+			return &object.Float{Value: -right.Value}
+		default:
+			return newError(node, "unsupported type for negation: %s", right.Type())
+		}
+	default:
+		return newError(node, "unknown operator: %s%s", operator, right.Type())
+	}
+}
+
+// evalLogicalExpression evaluates the right operand of && and || only
+// when the left one does not decide the result
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	if isTruthy(left) == (node.Operator == "||") {
+		return nativeBoolToBooleanObject(isTruthy(left))
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+func evalInfixExpression(node ast.Node, operator string, left, right object.Object) object.Object {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return evalIntegerInfixExpression(node, operator, left, right)
+	case isNumber(left) && isNumber(right):
+		// One of them is a float, so the integer is promoted
+		return evalFloatInfixExpression(node, operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(node, operator, left, right)
+	case operator == "==":
+		return nativeBoolToBooleanObject(object.Equal(left, right))
+	case operator == "!=":
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
+	case left.Type() != right.Type():
+		return newError(node, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	default:
+		return newError(node, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalIntegerInfixExpression(node ast.Node, operator string, left, right object.Object) object.Object {
+	switch operator {
+	case "+", "-", "*", "/", "%":
+		result, err := object.IntegerArithmetic(operator, left, right)
+		if err != nil {
+			return newError(node, "%s", err)
+		}
+		return result
+	}
+
+	cmp := object.CompareIntegers(left, right)
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(cmp < 0)
+	case ">":
+		return nativeBoolToBooleanObject(cmp > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(cmp <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(cmp >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(cmp == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(cmp != 0)
+	default:
+		return newError(node, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalFloatInfixExpression(node ast.Node, operator string, left, right object.Object) object.Object {
+	leftVal, _ := object.AsFloat(left)
+	rightVal, _ := object.AsFloat(right)
+
 	switch operator {
 	case "+":
-		return left + right
+		return &object.Float{Value: leftVal + rightVal}
 	case "-":
-		return left - right
+		return &object.Float{Value: leftVal - rightVal}
 	case "*":
-		return left * right
+		return &object.Float{Value: leftVal * rightVal}
 	case "/":
-		if right == 0 {
-			fmt.Println("Error: Division by zero")
-			return 0
+		if rightVal == 0 {
+			return newError(node, "division by zero")
 		}
-		return left / right
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError(node, "modulo by zero")
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError(node, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	_, ok := object.AsFloat(obj)
+	return ok
+}
+
+func evalStringInfixExpression(node ast.Node, operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return 0
+		return newError(node, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
-}
\ No newline at end of file
+}
+
+func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	hash := object.NewHash()
+
+	for i, keyNode := range node.Keys {
+		key := Eval(keyNode, env)
+		if isError(key) {
+			return key
+		}
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError(keyNode, "unusable as hash key: %s", key.Type())
+		}
+
+		value := Eval(node.Values[i], env)
+		if isError(value) {
+			return value
+		}
+		hash.Set(hashKey, value)
+	}
+
+	return hash
+}
+
+func evalIndexExpression(node ast.Node, left, index object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, err := object.ArrayIndex(index, len(left.Elements))
+		if err != nil {
+			return newError(node, "%s", err)
+		}
+		return left.Elements[i]
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError(node, "unusable as hash key: %s", index.Type())
+		}
+		if value, ok := left.Get(key); ok {
+			return value
+		}
+		return NULL
+
+	default:
+		return newError(node, "index operator not supported: %s", left.Type())
+	}
+}
+
+// memberKey returns the key a.b looks up, the string "b"
+func memberKey(property *ast.Identifier) object.Object {
+	return &object.String{Value: property.Value}
+}
+
+// evalAssignment assigns to a variable, element or member. Like the
+// compiled code, it evaluates the target's subexpressions once and before
+// the value, and a compound assignment reads the current value in between.
+func evalAssignment(node *ast.AssignmentStatement, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if node.BinaryOperator() != "" {
+			var ok bool
+			if current, ok = env.Get(target.Value); !ok {
+				return newError(node, "assignment to undeclared variable %s", target.Value)
+			}
+		}
+		val := evalAssignedValue(node, current, env)
+		if isError(val) {
+			return val
+		}
+		if !env.Assign(target.Value, val) {
+			return newError(node, "assignment to undeclared variable %s", target.Value)
+		}
+		return NULL
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		return evalElementAssignment(node, left, index, env)
+
+	case *ast.MemberExpression:
+		left := Eval(target.Object, env)
+		if isError(left) {
+			return left
+		}
+		return evalElementAssignment(node, left, memberKey(target.Property), env)
+	}
+
+	return newError(node, "cannot assign to %s", node.Target.String())
+}
+
+// evalAssignedValue evaluates the value being assigned, combining it with
+// the target's current value for a compound assignment. ++ and -- have an
+// implicit value of 1.
+func evalAssignedValue(node *ast.AssignmentStatement, current object.Object, env *object.Environment) object.Object {
+	var val object.Object = &object.Integer{Value: 1}
+	if node.Value != nil {
+		val = Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+	}
+
+	operator := node.BinaryOperator()
+	if operator == "" {
+		return val
+	}
+	return evalInfixExpression(node, operator, current, val)
+}
+
+func evalElementAssignment(node *ast.AssignmentStatement, left, index object.Object, env *object.Environment) object.Object {
+	var current object.Object
+	if node.BinaryOperator() != "" {
+		current = evalIndexExpression(node, left, index)
+		if isError(current) {
+			return current
+		}
+	}
+	val := evalAssignedValue(node, current, env)
+	if isError(val) {
+		return val
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		i, err := object.ArrayIndex(index, len(left.Elements))
+		if err != nil {
+			return newError(node, "%s", err)
+		}
+		left.Elements[i] = val
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError(node, "unusable as hash key: %s", index.Type())
+		}
+		left.Set(key, val)
+
+	default:
+		return newError(node, "index assignment not supported: %s", left.Type())
+	}
+	return NULL
+}
+
+func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+	var result []object.Object
+
+	for _, e := range exps {
+		evaluated := Eval(e, env)
+		if isError(evaluated) {
+			return []object.Object{evaluated}
+		}
+		result = append(result, evaluated)
+	}
+
+	return result
+}
+
+// applyFunction calls fn from caller, the environment of the call
+func applyFunction(node ast.Node, fn object.Object, args []object.Object, caller *object.Environment) object.Object {
+	switch fn := fn.(type) {
+	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError(node, "wrong number of arguments: want=%d, got=%d",
+				len(fn.Parameters), len(args))
+		}
+		if caller.Depth() >= MaxCallDepth {
+			return newError(node, "stack overflow")
+		}
+
+		env := object.NewCallEnvironment(fn.Env, caller)
+		for i, param := range fn.Parameters {
+			env.Set(param.Value, args[i])
+		}
+
+		evaluated := Eval(fn.Body, env)
+		if returnValue, ok := evaluated.(*object.ReturnValue); ok {
+			return returnValue.Value
+		}
+		return evaluated
+
+	case *object.Builtin:
+		result := fn.Fn(args...)
+		if err, ok := result.(*object.Error); ok {
+			return newError(node, "%s", err.Message)
+		}
+		return result
+
+	default:
+		return newError(node, "not a function: %s", fn.Type())
+	}
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case NULL, FALSE:
+		return false
+	default:
+		return true
+	}
+}
+
+func isError(obj object.Object) bool {
+	return obj != nil && obj.Type() == object.ERROR_OBJ
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	return object.NativeBool(input)
+}
+
+// newError creates an error value that cites the position of node
+func newError(node ast.Node, format string, a ...interface{}) *object.Error {
+	msg := fmt.Sprintf(format, a...)
+	if pos := node.Pos(); pos.IsValid() {
+		msg = pos.String() + ": " + msg
+	}
+	return &object.Error{Message: msg}
+}
@@ -0,0 +1,367 @@
+// Package format prints a parsed program back as canonically formatted
+// source code.
+package format
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/RavenStorm-bit/toy-compiler/ast"
+	"github.com/RavenStorm-bit/toy-compiler/token"
+)
+
+// Indent is the text used for one level of indentation
+const Indent = "    "
+
+// Binding strength of the operators, weakest first. These mirror the
+// parser's precedences and decide where parentheses are needed.
+const (
+	_ int = iota
+	lowest
+	or          // ||
+	and         // &&
+	equals      // == !=
+	lessGreater // < > <= >=
+	sum         // + -
+	product     // * / %
+	prefix      // -x !x
+	postfix     // f(x) a[i] a.b
+	primary     // literals, identifiers and anything ending in a block
+)
+
+var precedences = map[string]int{
+	"||": or,
+	"&&": and,
+	"==": equals,
+	"!=": equals,
+	"<":  lessGreater,
+	">":  lessGreater,
+	"<=": lessGreater,
+	">=": lessGreater,
+	"+":  sum,
+	"-":  sum,
+	"*":  product,
+	"/":  product,
+	"%":  product,
+}
+
+// Source formats program. Statements go one per line, blocks are indented
+// with Indent, and a single blank line between statements is kept.
+// Comments are kept on their own lines, or after the statement they
+// trail; to keep ordinary comments, parse with a lexer that emits them.
+func Source(program *ast.Program) string {
+	p := &printer{comments: program.Comments}
+	p.statements(program.Statements, nil)
+	if p.out.Len() > 0 {
+		p.write("\n")
+	}
+	return p.out.String()
+}
+
+type printer struct {
+	out    bytes.Buffer
+	indent int
+
+	comments []token.Token // not yet printed, in source order
+	lastLine int           // source line the last printed item ended on
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.write("\n" + strings.Repeat(Indent, p.indent))
+}
+
+// statements prints stmts and the comments among them. Comments before
+// end, the closing brace of the enclosing block, are printed too; a nil
+// end takes every remaining comment.
+func (p *printer) statements(stmts []ast.Statement, end *token.Position) {
+	p.lastLine = 0
+	for i, s := range stmts {
+		for p.commentBefore(s.Pos()) {
+			p.comment()
+		}
+
+		p.item(s.Pos().Line, s.End().Line)
+		p.statement(s)
+		p.lastLine = s.End().Line
+
+		// Comments on the line the statement ends on stay after it
+		line := s.End().Line
+		for len(p.comments) > 0 && p.comments[0].Span.Start.Line == line {
+			if i+1 < len(stmts) && !p.commentBefore(stmts[i+1].Pos()) {
+				break
+			}
+			if end != nil && !p.commentBefore(*end) {
+				break
+			}
+			c := p.comments[0]
+			p.comments = p.comments[1:]
+			p.write(" " + c.Literal)
+			p.lastLine = c.Span.End.Line
+		}
+	}
+
+	for len(p.comments) > 0 && (end == nil || p.commentBefore(*end)) {
+		p.comment()
+	}
+}
+
+// item starts a new line for something spanning the source lines first
+// to last, keeping a single blank line if the source had one
+func (p *printer) item(first, last int) {
+	if p.lastLine > 0 {
+		p.newline()
+		if first > p.lastLine+1 {
+			p.newline()
+		}
+	}
+	p.lastLine = last
+}
+
+func (p *printer) commentBefore(pos token.Position) bool {
+	return len(p.comments) > 0 && p.comments[0].Span.Start.Offset < pos.Offset
+}
+
+// comment prints the next comment on a line of its own
+func (p *printer) comment() {
+	c := p.comments[0]
+	p.comments = p.comments[1:]
+	p.item(c.Span.Start.Line, c.Span.End.Line)
+	p.write(c.Literal)
+}
+
+func (p *printer) statement(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement, *ast.AssignmentStatement:
+		p.simpleStatement(s)
+		p.write(";")
+
+	case *ast.ReturnStatement:
+		if s.ReturnValue == nil {
+			p.write("return;")
+			return
+		}
+		p.write("return ")
+		p.expression(s.ReturnValue, lowest)
+		p.write(";")
+
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expression(s.Condition, lowest)
+		p.write(") ")
+		p.block(s.Body)
+
+	case *ast.ForStatement:
+		p.write("for (")
+		if s.Init != nil {
+			p.simpleStatement(s.Init)
+		}
+		p.write(";")
+		if s.Condition != nil {
+			p.write(" ")
+			p.expression(s.Condition, lowest)
+		}
+		p.write(";")
+		if s.Post != nil {
+			p.write(" ")
+			p.simpleStatement(s.Post)
+		}
+		p.write(") ")
+		p.block(s.Body)
+
+	case *ast.ForInStatement:
+		names := make([]string, len(s.Variables))
+		for i, v := range s.Variables {
+			names[i] = v.Value
+		}
+		p.write("for " + strings.Join(names, ", ") + " in ")
+		p.expression(s.Iterable, lowest)
+		p.write(" ")
+		p.block(s.Body)
+
+	case *ast.BreakStatement:
+		p.write("break;")
+
+	case *ast.ContinueStatement:
+		p.write("continue;")
+
+	case *ast.ExpressionStatement:
+		p.expression(s.Expression, lowest)
+		if _, ok := s.Expression.(*ast.IfExpression); !ok {
+			p.write(";")
+		}
+
+	case *ast.BlockStatement:
+		p.block(s)
+	}
+}
+
+// simpleStatement writes a statement that can also be the init or post
+// clause of a for loop, without its semicolon
+func (p *printer) simpleStatement(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		p.write("let " + s.Name.Value + " = ")
+		p.expression(s.Value, lowest)
+
+	case *ast.AssignmentStatement:
+		switch {
+		case s.Prefix:
+			p.write(s.Operator)
+			p.expression(s.Target, postfix)
+		case s.Value == nil:
+			p.expression(s.Target, postfix)
+			p.write(s.Operator)
+		default:
+			p.expression(s.Target, lowest)
+			p.write(" " + s.Operator + " ")
+			p.expression(s.Value, lowest)
+		}
+
+	case *ast.ExpressionStatement:
+		p.expression(s.Expression, lowest)
+	}
+}
+
+func (p *printer) block(b *ast.BlockStatement) {
+	end := b.Rbrace.Span.Start
+	if len(b.Statements) == 0 && !p.commentBefore(end) {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.indent++
+	p.newline()
+	p.statements(b.Statements, &end)
+	p.indent--
+	p.newline()
+	p.write("}")
+	p.lastLine = b.Rbrace.Span.End.Line
+}
+
+// expression writes e, wrapped in parentheses if it binds more loosely
+// than its context requires
+func (p *printer) expression(e ast.Expression, minPrec int) {
+	if precedenceOf(e) < minPrec {
+		p.write("(")
+		defer p.write(")")
+	}
+
+	switch e := e.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+
+	case *ast.IntegerLiteral, *ast.FloatLiteral:
+		// The literal as written keeps its base and digit grouping
+		p.write(e.TokenLiteral())
+
+	case *ast.StringLiteral:
+		// The literal as written keeps its escapes and quoting style
+		p.write(e.Token.Literal)
+
+	case *ast.Boolean:
+		if e.Value {
+			p.write("true")
+		} else {
+			p.write("false")
+		}
+
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		// Keep "- -x" from running together as "--x"
+		if inner, ok := e.Right.(*ast.PrefixExpression); ok && inner.Operator == "-" && e.Operator == "-" {
+			p.write("(")
+			defer p.write(")")
+		}
+		p.expression(e.Right, prefix)
+
+	case *ast.InfixExpression:
+		prec := precedences[e.Operator]
+		// Operators are left-associative, so a right operand of the same
+		// precedence needs parentheses to keep its grouping.
+		p.expression(e.Left, prec)
+		p.write(" " + e.Operator + " ")
+		p.expression(e.Right, prec+1)
+
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expression(e.Condition, lowest)
+		p.write(") ")
+		p.block(e.Consequence)
+		if e.Alternative != nil {
+			p.write(" else ")
+			if e.Alternative.IsElseIf() {
+				p.expression(e.Alternative.Statements[0].(*ast.ExpressionStatement).Expression, lowest)
+			} else {
+				p.block(e.Alternative)
+			}
+		}
+
+	case *ast.FunctionLiteral:
+		params := make([]string, len(e.Parameters))
+		for i, param := range e.Parameters {
+			params[i] = param.Value
+		}
+		p.write("fn(" + strings.Join(params, ", ") + ") ")
+		p.block(e.Body)
+
+	case *ast.CallExpression:
+		p.expression(e.Function, postfix)
+		p.write("(")
+		p.list(e.Arguments)
+		p.write(")")
+
+	case *ast.ArrayLiteral:
+		p.write("[")
+		p.list(e.Elements)
+		p.write("]")
+
+	case *ast.HashLiteral:
+		p.write("{")
+		for i, key := range e.Keys {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(key, lowest)
+			p.write(": ")
+			p.expression(e.Values[i], lowest)
+		}
+		p.write("}")
+
+	case *ast.IndexExpression:
+		p.expression(e.Left, postfix)
+		p.write("[")
+		p.expression(e.Index, lowest)
+		p.write("]")
+
+	case *ast.MemberExpression:
+		p.expression(e.Object, postfix)
+		p.write("." + e.Property.Value)
+	}
+}
+
+func (p *printer) list(exps []ast.Expression) {
+	for i, e := range exps {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expression(e, lowest)
+	}
+}
+
+func precedenceOf(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return precedences[e.Operator]
+	case *ast.PrefixExpression:
+		return prefix
+	case *ast.CallExpression, *ast.IndexExpression, *ast.MemberExpression:
+		return postfix
+	default:
+		return primary
+	}
+}
@@ -1,23 +1,55 @@
 package lexer
 
 import (
+    "strings"
+
     "github.com/RavenStorm-bit/toy-compiler/token"
 )
 
 type Lexer struct {
+    filename     string
     input        string
     position     int  // current position in input (points to current char)
     readPosition int  // current reading position in input (after current char)
     ch           byte // current char under examination
+    line         int  // line of the current char, starting at 1
+    column       int  // column of the current char, starting at 1
+
+    emitComments bool // return COMMENT tokens instead of skipping them
 }
 
 func New(input string) *Lexer {
-    l := &Lexer{input: input}
+    return NewFile("", input)
+}
+
+// NewFile creates a lexer whose token positions carry filename
+func NewFile(filename, input string) *Lexer {
+    l := &Lexer{filename: filename, input: input, line: 1}
     l.readChar()
     return l
 }
 
+// SetEmitComments makes the lexer return ordinary comments as COMMENT
+// tokens instead of skipping them. Doc comments are always returned.
+func (l *Lexer) SetEmitComments(emit bool) {
+    l.emitComments = emit
+}
+
 func (l *Lexer) readChar() {
+    if l.readPosition > len(l.input) {
+        // Already at EOF; stay there so its position is stable
+        return
+    }
+
+    // Advance the line/column of the char we are about to leave. A "\r\n"
+    // pair counts as a single line break, on the '\n'.
+    if l.ch == '\n' || (l.ch == '\r' && l.peekChar() != '\n') {
+        l.line++
+        l.column = 1
+    } else {
+        l.column++
+    }
+
     if l.readPosition >= len(l.input) {
         l.ch = 0
     } else {
@@ -27,10 +59,32 @@ func (l *Lexer) readChar() {
     l.readPosition += 1
 }
 
+// pos returns the position of the current char
+func (l *Lexer) pos() token.Position {
+    return token.Position{
+        Filename: l.filename,
+        Offset:   l.position,
+        Line:     l.line,
+        Column:   l.column,
+    }
+}
+
 func (l *Lexer) NextToken() token.Token {
-    var tok token.Token
+    for {
+        l.skipWhitespace()
 
-    l.skipWhitespace()
+        start := l.pos()
+        tok := l.scanToken()
+        tok.Span = token.Span{Start: start, End: l.pos()}
+        if tok.Type == token.COMMENT && !l.emitComments {
+            continue
+        }
+        return tok
+    }
+}
+
+func (l *Lexer) scanToken() token.Token {
+    var tok token.Token
 
     switch l.ch {
     case '=':
@@ -47,20 +101,41 @@ func (l *Lexer) NextToken() token.Token {
             l.readChar()
             tok = token.Token{Type: token.NOT_EQ, Literal: string(ch) + string(l.ch)}
         } else {
-            tok = newToken(token.ILLEGAL, l.ch)
+            tok = newToken(token.BANG, l.ch)
         }
     case '<':
-        tok = newToken(token.LT, l.ch)
+        tok = l.either('=', token.LT_EQ, token.LT)
     case '>':
-        tok = newToken(token.GT, l.ch)
+        tok = l.either('=', token.GT_EQ, token.GT)
+    case '&':
+        tok = l.either('&', token.AND, token.ILLEGAL)
+    case '|':
+        tok = l.either('|', token.OR, token.ILLEGAL)
+    case '%':
+        tok = l.either('=', token.PERCENT_ASSIGN, token.PERCENT)
     case '+':
-        tok = newToken(token.PLUS, l.ch)
+        if l.peekChar() == '+' {
+            tok = l.either('+', token.INC, token.PLUS)
+        } else {
+            tok = l.either('=', token.PLUS_ASSIGN, token.PLUS)
+        }
     case '-':
-        tok = newToken(token.MINUS, l.ch)
+        if l.peekChar() == '-' {
+            tok = l.either('-', token.DEC, token.MINUS)
+        } else {
+            tok = l.either('=', token.MINUS_ASSIGN, token.MINUS)
+        }
     case '*':
-        tok = newToken(token.ASTERISK, l.ch)
+        tok = l.either('=', token.ASTERISK_ASSIGN, token.ASTERISK)
     case '/':
-        tok = newToken(token.SLASH, l.ch)
+        switch l.peekChar() {
+        case '/':
+            return l.readLineComment()
+        case '*':
+            return l.readBlockComment()
+        default:
+            tok = l.either('=', token.SLASH_ASSIGN, token.SLASH)
+        }
     case '(':
         tok = newToken(token.LPAREN, l.ch)
     case ')':
@@ -69,21 +144,28 @@ func (l *Lexer) NextToken() token.Token {
         tok = newToken(token.LBRACE, l.ch)
     case '}':
         tok = newToken(token.RBRACE, l.ch)
+    case '[':
+        tok = newToken(token.LBRACKET, l.ch)
+    case ']':
+        tok = newToken(token.RBRACKET, l.ch)
     case ';':
         tok = newToken(token.SEMICOLON, l.ch)
+    case ':':
+        tok = newToken(token.COLON, l.ch)
     case ',':
         tok = newToken(token.COMMA, l.ch)
+    case '.':
+        tok = newToken(token.DOT, l.ch)
     case '"':
-        tok.Type = token.STRING
-        tok.Literal = l.readString()
+        return l.readString()
+    case '`':
+        return l.readRawString()
     case 0:
         tok.Literal = ""
         tok.Type = token.EOF
     default:
         if isDigit(l.ch) {
-            tok.Type = token.INT
-            tok.Literal = l.readNumber()
-            return tok
+            return l.readNumber()
         } else if isLetter(l.ch) {
             tok.Literal = l.readIdentifier()
             tok.Type = token.LookupIdent(tok.Literal)
@@ -97,33 +179,138 @@ func (l *Lexer) NextToken() token.Token {
     return tok
 }
 
+// either returns a two-character token of type two if the next char is
+// next, and a one-character token of type one otherwise
+func (l *Lexer) either(next byte, two, one token.TokenType) token.Token {
+    if l.peekChar() == next {
+        ch := l.ch
+        l.readChar()
+        return token.Token{Type: two, Literal: string(ch) + string(l.ch)}
+    }
+    return newToken(one, l.ch)
+}
+
 func (l *Lexer) skipWhitespace() {
     for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
         l.readChar()
     }
 }
 
-func (l *Lexer) readNumber() string {
+// readLineComment reads a comment running to the end of the line. Exactly
+// three leading slashes make it a doc comment; four or more do not, so
+// "////" rulers stay ordinary comments.
+func (l *Lexer) readLineComment() token.Token {
     position := l.position
-    for isDigit(l.ch) {
+    for l.ch != '\n' && l.ch != '\r' && l.ch != 0 {
+        l.readChar()
+    }
+    text := l.input[position:l.position]
+
+    tokenType := token.TokenType(token.COMMENT)
+    if strings.HasPrefix(text, "///") && !strings.HasPrefix(text, "////") {
+        tokenType = token.DOC_COMMENT
+    }
+    return token.Token{Type: tokenType, Literal: text}
+}
+
+// readBlockComment reads a /* */ comment. Block comments nest, so a
+// commented-out region may itself contain comments. An unterminated
+// comment is returned as an ILLEGAL "/*" token spanning the rest of the
+// input.
+func (l *Lexer) readBlockComment() token.Token {
+    position := l.position
+    depth := 0
+    for {
+        switch {
+        case l.ch == 0:
+            return token.Token{Type: token.ILLEGAL, Literal: "/*"}
+        case l.ch == '/' && l.peekChar() == '*':
+            depth++
+            l.readChar()
+        case l.ch == '*' && l.peekChar() == '/':
+            depth--
+            l.readChar()
+            if depth == 0 {
+                l.readChar()
+                return token.Token{Type: token.COMMENT, Literal: l.input[position:l.position]}
+            }
+        }
+        l.readChar()
+    }
+}
+
+// readNumber reads an integer or float literal. Integers may have a 0x,
+// 0o or 0b base prefix, and digits may be grouped with underscores. The
+// lexer only finds where the literal ends; the parser checks its digits.
+func (l *Lexer) readNumber() token.Token {
+    position := l.position
+
+    if l.ch == '0' && isBasePrefix(l.peekChar()) {
+        l.readChar()
+        l.readChar()
+        for isHexDigit(l.ch) || l.ch == '_' {
+            l.readChar()
+        }
+        return token.Token{Type: token.INT, Literal: l.input[position:l.position]}
+    }
+
+    tokenType := token.TokenType(token.INT)
+    l.readDigits()
+    if l.ch == '.' && isDigit(l.peekChar()) {
+        tokenType = token.FLOAT
+        l.readChar()
+        l.readDigits()
+    }
+    if l.ch == 'e' || l.ch == 'E' {
+        next := l.peekChar()
+        if isDigit(next) || ((next == '+' || next == '-') && isDigit(l.peekCharAt(2))) {
+            tokenType = token.FLOAT
+            l.readChar()
+            if l.ch == '+' || l.ch == '-' {
+                l.readChar()
+            }
+            l.readDigits()
+        }
+    }
+    return token.Token{Type: tokenType, Literal: l.input[position:l.position]}
+}
+
+func (l *Lexer) readDigits() {
+    for isDigit(l.ch) || l.ch == '_' {
         l.readChar()
     }
-    return l.input[position:l.position]
 }
 
 func isDigit(ch byte) bool {
     return '0' <= ch && ch <= '9'
 }
 
+func isHexDigit(ch byte) bool {
+    return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func isBasePrefix(ch byte) bool {
+    switch ch {
+    case 'x', 'X', 'o', 'O', 'b', 'B':
+        return true
+    }
+    return false
+}
+
 func newToken(tokenType token.TokenType, ch byte) token.Token {
     return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
 func (l *Lexer) peekChar() byte {
-    if l.readPosition >= len(l.input) {
+    return l.peekCharAt(1)
+}
+
+// peekCharAt returns the char n places after the current one
+func (l *Lexer) peekCharAt(n int) byte {
+    if l.position+n >= len(l.input) {
         return 0
     }
-    return l.input[l.readPosition]
+    return l.input[l.position+n]
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -134,15 +321,42 @@ func (l *Lexer) readIdentifier() string {
     return l.input[position:l.position]
 }
 
-func (l *Lexer) readString() string {
-    position := l.position + 1
+// readString reads a double-quoted string up to its closing quote,
+// stepping over escaped characters. The literal is the source text,
+// quotes and escapes included; Unquote decodes it. A string still open
+// at the end of its line is returned as ILLEGAL.
+func (l *Lexer) readString() token.Token {
+    position := l.position
+    for {
+        l.readChar()
+        switch l.ch {
+        case '\\':
+            if next := l.peekChar(); next != '\n' && next != '\r' && next != 0 {
+                l.readChar()
+            }
+        case '"':
+            l.readChar()
+            return token.Token{Type: token.STRING, Literal: l.input[position:l.position]}
+        case '\n', '\r', 0:
+            return token.Token{Type: token.ILLEGAL, Literal: l.input[position:l.position]}
+        }
+    }
+}
+
+// readRawString reads a backquoted string, which may span lines and has
+// no escapes
+func (l *Lexer) readRawString() token.Token {
+    position := l.position
     for {
         l.readChar()
-        if l.ch == '"' || l.ch == 0 {
-            break
+        switch l.ch {
+        case '`':
+            l.readChar()
+            return token.Token{Type: token.STRING, Literal: l.input[position:l.position]}
+        case 0:
+            return token.Token{Type: token.ILLEGAL, Literal: l.input[position:l.position]}
         }
     }
-    return l.input[position:l.position]
 }
 
 func isLetter(ch byte) bool {
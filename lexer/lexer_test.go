@@ -35,4 +35,283 @@ func TestNextToken(t *testing.T) {
 				i, tt.expectedLiteral, tok.Literal)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\r\nlet yy = \"hi\";\n  x"
+
+	tests := []struct {
+		expectedLiteral string
+		line, column    int
+		offset, endCol  int
+	}{
+		{"let", 1, 1, 0, 4},
+		{"x", 1, 5, 4, 6},
+		{"=", 1, 7, 6, 8},
+		{"5", 1, 9, 8, 10},
+		{";", 1, 10, 9, 11},
+		{"let", 2, 1, 12, 4},
+		{"yy", 2, 5, 16, 7},
+		{"=", 2, 8, 19, 9},
+		{`"hi"`, 2, 10, 21, 14},
+		{";", 2, 14, 25, 15},
+		{"x", 3, 3, 29, 4},
+		{"", 3, 4, 30, 4},
+	}
+
+	l := NewFile("test.toy", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		start := tok.Span.Start
+		if start.Line != tt.line || start.Column != tt.column || start.Offset != tt.offset {
+			t.Fatalf("tests[%d] - start wrong. expected=%d:%d@%d, got=%d:%d@%d",
+				i, tt.line, tt.column, tt.offset, start.Line, start.Column, start.Offset)
+		}
+
+		if tok.Span.End.Line != tt.line || tok.Span.End.Column != tt.endCol {
+			t.Fatalf("tests[%d] - end wrong. expected=%d:%d, got=%d:%d",
+				i, tt.line, tt.endCol, tok.Span.End.Line, tok.Span.End.Column)
+		}
+
+		if start.Filename != "test.toy" {
+			t.Fatalf("tests[%d] - filename wrong. got=%q", i, start.Filename)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `a // line
+/* block /* nested */ still */ b
+/// doc
+//// ruler
+c /* open`
+
+	tests := []struct {
+		emit     bool
+		expected []token.Token
+	}{
+		{false, []token.Token{
+			{Type: token.IDENT, Literal: "a"},
+			{Type: token.IDENT, Literal: "b"},
+			{Type: token.DOC_COMMENT, Literal: "/// doc"},
+			{Type: token.IDENT, Literal: "c"},
+			{Type: token.ILLEGAL, Literal: "/*"},
+			{Type: token.EOF, Literal: ""},
+		}},
+		{true, []token.Token{
+			{Type: token.IDENT, Literal: "a"},
+			{Type: token.COMMENT, Literal: "// line"},
+			{Type: token.COMMENT, Literal: "/* block /* nested */ still */"},
+			{Type: token.IDENT, Literal: "b"},
+			{Type: token.DOC_COMMENT, Literal: "/// doc"},
+			{Type: token.COMMENT, Literal: "//// ruler"},
+			{Type: token.IDENT, Literal: "c"},
+			{Type: token.ILLEGAL, Literal: "/*"},
+			{Type: token.EOF, Literal: ""},
+		}},
+	}
+
+	for _, tt := range tests {
+		l := New(input)
+		l.SetEmitComments(tt.emit)
+
+		for i, want := range tt.expected {
+			tok := l.NextToken()
+			if tok.Type != want.Type || tok.Literal != want.Literal {
+				t.Fatalf("emit=%t tests[%d] - expected %s %q, got %s %q",
+					tt.emit, i, want.Type, want.Literal, tok.Type, tok.Literal)
+			}
+		}
+	}
+}
+
+func TestStringTokens(t *testing.T) {
+	input := "\"a\\\"b\" `x\ny` \"open\nz \"end"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		line            int
+	}{
+		{token.STRING, `"a\"b"`, 1},
+		{token.STRING, "`x\ny`", 1},
+		{token.ILLEGAL, `"open`, 2},
+		{token.IDENT, "z", 3},
+		{token.ILLEGAL, `"end`, 3},
+		{token.EOF, "", 3},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Span.Start.Line != tt.line {
+			t.Fatalf("tests[%d] - expected line %d, got %d", i, tt.line, tok.Span.Start.Line)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		literal  string
+		expected string
+		err      string
+		offset   int
+	}{
+		{`"plain"`, "plain", "", 0},
+		{`"a\nb\tc\r"`, "a\nb\tc\r", "", 0},
+		{`"\\ \""`, `\ "`, "", 0},
+		{`"\u{41}\u{e9}\u{1F600}"`, "Aé😀", "", 0},
+		{"`a\\n\r\nb`", "a\\n\nb", "", 0},
+		{`"ok \q"`, "", `unknown escape sequence \q`, 4},
+		{`"\u0041"`, "", `\u must be followed by {hex digits}`, 1},
+		{`"x\u{}"`, "", `\u{...} needs one to six hex digits`, 2},
+		{`"\u{zz}"`, "", `invalid hex digits in \u{zz}`, 1},
+		{`"\u{D800}"`, "", `\u{D800} is not a valid code point`, 1},
+		{`"\u{110000}"`, "", `\u{110000} is not a valid code point`, 1},
+	}
+
+	for _, tt := range tests {
+		got, err := Unquote(tt.literal)
+		if tt.err == "" {
+			if err != nil || got != tt.expected {
+				t.Errorf("Unquote(%s): want %q, got %q (err %v)", tt.literal, tt.expected, got, err)
+			}
+			continue
+		}
+
+		e, ok := err.(*EscapeError)
+		if !ok {
+			t.Errorf("Unquote(%s): want EscapeError, got %v", tt.literal, err)
+			continue
+		}
+		if e.Message != tt.err || e.Offset != tt.offset {
+			t.Errorf("Unquote(%s): want %q at %d, got %q at %d",
+				tt.literal, tt.err, tt.offset, e.Message, e.Offset)
+		}
+	}
+}
+
+func TestBracketTokens(t *testing.T) {
+	input := `[1, a][0]`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.IDENT, "a"},
+		{token.RBRACKET, "]"},
+		{token.LBRACKET, "["},
+		{token.INT, "0"},
+		{token.RBRACKET, "]"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestOperatorTokens(t *testing.T) {
+	input := `!a != -b <= c >= d % e && f || g < h > i & |`
+
+	expected := []token.TokenType{
+		token.BANG, token.IDENT, token.NOT_EQ, token.MINUS, token.IDENT,
+		token.LT_EQ, token.IDENT, token.GT_EQ, token.IDENT, token.PERCENT,
+		token.IDENT, token.AND, token.IDENT, token.OR, token.IDENT, token.LT,
+		token.IDENT, token.GT, token.IDENT, token.ILLEGAL, token.ILLEGAL,
+		token.EOF,
+	}
+
+	l := New(input)
+
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q (%q)",
+				i, want, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestAssignmentTokens(t *testing.T) {
+	input := `a += 1 -= b *= c /= d %= e = x++ --y - -z + +w a.b`
+
+	expected := []token.TokenType{
+		token.IDENT, token.PLUS_ASSIGN, token.INT, token.MINUS_ASSIGN, token.IDENT,
+		token.ASTERISK_ASSIGN, token.IDENT, token.SLASH_ASSIGN, token.IDENT,
+		token.PERCENT_ASSIGN, token.IDENT, token.ASSIGN, token.IDENT, token.INC,
+		token.DEC, token.IDENT, token.MINUS, token.MINUS, token.IDENT, token.PLUS,
+		token.PLUS, token.IDENT, token.IDENT, token.DOT, token.IDENT, token.EOF,
+	}
+
+	l := New(input)
+
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q (%q)",
+				i, want, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestNumberTokens(t *testing.T) {
+	input := `42 3.14 1e9 2.5E-3 1_000 0xff 0o17 0b101 7.e 1.x 5e+`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "42"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "2.5E-3"},
+		{token.INT, "1_000"},
+		{token.INT, "0xff"},
+		{token.INT, "0o17"},
+		{token.INT, "0b101"},
+		// A dot or exponent marker without digits after it is not part
+		// of the number
+		{token.INT, "7"},
+		{token.DOT, "."},
+		{token.IDENT, "e"},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
+		{token.INT, "5"},
+		{token.IDENT, "e"},
+		{token.PLUS, "+"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
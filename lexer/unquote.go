@@ -0,0 +1,96 @@
+package lexer
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// EscapeError reports an invalid escape sequence in a string literal
+type EscapeError struct {
+    Offset  int // byte offset of the backslash within the literal
+    Length  int // length of the offending sequence
+    Message string
+}
+
+func (e *EscapeError) Error() string { return e.Message }
+
+// Unquote returns the value of a STRING token's literal. Raw `strings`
+// are taken as written, minus carriage returns; double-quoted strings
+// have their escapes decoded: \n \r \t \\ \" and \u{XXXX} with one to six
+// hex digits naming a Unicode code point.
+func Unquote(literal string) (string, error) {
+    if strings.HasPrefix(literal, "`") {
+        raw := literal[1 : len(literal)-1]
+        return strings.ReplaceAll(raw, "\r", ""), nil
+    }
+
+    body := literal[1 : len(literal)-1]
+    if !strings.Contains(body, `\`) {
+        return body, nil
+    }
+
+    var out strings.Builder
+    for i := 0; i < len(body); i++ {
+        if body[i] != '\\' {
+            out.WriteByte(body[i])
+            continue
+        }
+
+        offset := 1 + i // account for the opening quote
+        if i+1 >= len(body) {
+            return "", &EscapeError{offset, 1, "unfinished escape sequence"}
+        }
+        i++
+        switch body[i] {
+        case 'n':
+            out.WriteByte('\n')
+        case 'r':
+            out.WriteByte('\r')
+        case 't':
+            out.WriteByte('\t')
+        case '\\':
+            out.WriteByte('\\')
+        case '"':
+            out.WriteByte('"')
+        case 'u':
+            r, n, err := unicodeEscape(body[i+1:])
+            if err != "" {
+                return "", &EscapeError{offset, 2 + n, err}
+            }
+            out.WriteRune(r)
+            i += n
+        default:
+            _, size := utf8.DecodeRuneInString(body[i:])
+            return "", &EscapeError{offset, 1 + size,
+                fmt.Sprintf("unknown escape sequence \\%s", body[i:i+size])}
+        }
+    }
+    return out.String(), nil
+}
+
+// unicodeEscape decodes the "{XXXX}" after \u. It returns the rune, the
+// number of bytes consumed, and a message if the escape is malformed.
+func unicodeEscape(s string) (rune, int, string) {
+    if !strings.HasPrefix(s, "{") {
+        return 0, 0, `\u must be followed by {hex digits}`
+    }
+    end := strings.IndexByte(s, '}')
+    if end < 0 {
+        return 0, len(s), `unterminated \u{...} escape`
+    }
+
+    digits := s[1:end]
+    if len(digits) == 0 || len(digits) > 6 {
+        return 0, end + 1, `\u{...} needs one to six hex digits`
+    }
+    v, err := strconv.ParseUint(digits, 16, 32)
+    if err != nil {
+        return 0, end + 1, fmt.Sprintf(`invalid hex digits in \u{%s}`, digits)
+    }
+    if v > utf8.MaxRune || (v >= 0xD800 && v <= 0xDFFF) {
+        return 0, end + 1, fmt.Sprintf(`\u{%s} is not a valid code point`, digits)
+    }
+    return rune(v), end + 1, ""
+}
@@ -0,0 +1,74 @@
+package object
+
+import "sort"
+
+// Environment maps names to values. Each function call gets its own
+// environment enclosing the one the function was defined in.
+type Environment struct {
+	store map[string]Object
+	outer *Environment
+	depth int // how many calls deep the environment was created
+}
+
+// NewEnvironment creates an empty top-level environment
+func NewEnvironment() *Environment {
+	return &Environment{store: map[string]Object{}}
+}
+
+// NewEnclosedEnvironment creates an environment nested inside outer
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	env := NewEnvironment()
+	env.outer = outer
+	return env
+}
+
+// NewCallEnvironment creates the environment of a call made from caller
+// to a function defined in outer. It is one call deeper than caller.
+func NewCallEnvironment(outer, caller *Environment) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.depth = caller.depth + 1
+	return env
+}
+
+// Depth returns how many calls deep the environment was created
+func (e *Environment) Depth() int {
+	return e.depth
+}
+
+// Get looks name up in this environment and then its enclosing ones
+func (e *Environment) Get(name string) (Object, bool) {
+	obj, ok := e.store[name]
+	if !ok && e.outer != nil {
+		return e.outer.Get(name)
+	}
+	return obj, ok
+}
+
+// Names returns the names defined directly in this environment, sorted
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set defines name in this environment, shadowing any outer binding
+func (e *Environment) Set(name string, val Object) Object {
+	e.store[name] = val
+	return val
+}
+
+// Assign updates the nearest existing binding of name. It reports false
+// when name has not been defined.
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
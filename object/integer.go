@@ -0,0 +1,190 @@
+package object
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// BigInt is an integer too large for an Integer. It has the same type as
+// Integer, and every operation that produces one demotes its result back
+// to an Integer when it fits, so a BigInt never holds an int64 value.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType { return INTEGER_OBJ }
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+func (b *BigInt) HashKey() HashKey {
+	// No BigInt equals an Integer, so a separate key space keeps them from
+	// colliding
+	h := fnv.New64a()
+	h.Write([]byte(b.Value.String()))
+	return HashKey{Type: "BIGINT", Value: h.Sum64()}
+}
+
+// NewInteger returns v as an Integer if it fits in 64 bits, and as a
+// BigInt otherwise
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInt{Value: v}
+}
+
+// AsBig returns the value of an Integer or BigInt as a big.Int
+func AsBig(obj Object) (*big.Int, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value), true
+	case *BigInt:
+		return obj.Value, true
+	}
+	return nil, false
+}
+
+// IntegerArithmetic applies one of the operators + - * / % to two
+// integers of either size. Results that overflow 64 bits become BigInts.
+// Division truncates toward zero and the remainder takes the sign of the
+// dividend, whatever the size of the operands.
+func IntegerArithmetic(operator string, left, right Object) (Object, error) {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		if result, ok := smallArithmetic(operator, l.Value, r.Value); ok {
+			return &Integer{Value: result}, nil
+		}
+	}
+
+	a, _ := AsBig(left)
+	b, _ := AsBig(right)
+	result := new(big.Int)
+
+	switch operator {
+	case "+":
+		result.Add(a, b)
+	case "-":
+		result.Sub(a, b)
+	case "*":
+		result.Mul(a, b)
+	case "/":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result.Quo(a, b)
+	case "%":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("modulo by zero")
+		}
+		result.Rem(a, b)
+	default:
+		return nil, fmt.Errorf("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	return NewInteger(result), nil
+}
+
+// smallArithmetic computes a operator b in 64 bits. It reports false when
+// the result does not fit, or for anything it leaves to the big path:
+// unknown operators and division by zero.
+func smallArithmetic(operator string, a, b int64) (int64, bool) {
+	switch operator {
+	case "+":
+		sum := a + b
+		// Overflow iff both operands have the sign the sum lacks
+		return sum, (a^sum)&(b^sum) >= 0
+	case "-":
+		diff := a - b
+		return diff, (a^b)&(a^diff) >= 0
+	case "*":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		hi, lo := bits.Mul64(uint64(abs(a)), uint64(abs(b)))
+		negative := (a < 0) != (b < 0)
+		if hi != 0 || lo > math.MaxInt64+boolToUint64(negative) {
+			return 0, false
+		}
+		return a * b, true
+	case "/":
+		if b == 0 || (a == math.MinInt64 && b == -1) {
+			return 0, false
+		}
+		return a / b, true
+	case "%":
+		if b == 0 {
+			return 0, false
+		}
+		if b == -1 {
+			return 0, true
+		}
+		return a % b, true
+	}
+	return 0, false
+}
+
+// abs returns the magnitude of v; MinInt64 maps to itself, which reads
+// correctly once converted to uint64
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func boolToUint64(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// NegateInteger returns -v for an Integer or BigInt
+func NegateInteger(v Object) Object {
+	if i, ok := v.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	b, _ := AsBig(v)
+	return NewInteger(new(big.Int).Neg(b))
+}
+
+// CompareIntegers returns -1, 0 or +1 as a is less than, equal to or
+// greater than b, for integers of either size
+func CompareIntegers(a, b Object) int {
+	if x, ok := a.(*Integer); ok {
+		if y, ok := b.(*Integer); ok {
+			switch {
+			case x.Value < y.Value:
+				return -1
+			case x.Value > y.Value:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	x, _ := AsBig(a)
+	y, _ := AsBig(b)
+	return x.Cmp(y)
+}
+
+// ArrayIndex checks that index is an integer position within an array of
+// length n and returns it
+func ArrayIndex(index Object, n int) (int, error) {
+	switch index := index.(type) {
+	case *Integer:
+		if err := CheckIndex(index.Value, n); err != nil {
+			return 0, err
+		}
+		return int(index.Value), nil
+	case *BigInt:
+		if index.Value.Sign() < 0 {
+			return 0, fmt.Errorf("negative array index %s", index.Value)
+		}
+		return 0, fmt.Errorf("array index %s out of range (length %d)", index.Value, n)
+	default:
+		return 0, fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+	}
+}
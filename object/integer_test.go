@@ -0,0 +1,73 @@
+package object
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestIntegerArithmetic(t *testing.T) {
+	big2to63, _ := new(big.Int).SetString("9223372036854775808", 10)
+
+	tests := []struct {
+		left     Object
+		operator string
+		right    Object
+		expected string
+		small    bool // whether the result should be an Integer
+	}{
+		{&Integer{Value: 2}, "+", &Integer{Value: 3}, "5", true},
+		{&Integer{Value: math.MaxInt64}, "+", &Integer{Value: 1}, "9223372036854775808", false},
+		{&Integer{Value: math.MinInt64}, "-", &Integer{Value: 1}, "-9223372036854775809", false},
+		{&Integer{Value: math.MinInt64}, "+", &Integer{Value: -1}, "-9223372036854775809", false},
+		{&Integer{Value: 1 << 32}, "*", &Integer{Value: 1 << 31}, "9223372036854775808", false},
+		{&Integer{Value: -(1 << 32)}, "*", &Integer{Value: 1 << 31}, "-9223372036854775808", true},
+		{&Integer{Value: -3}, "*", &Integer{Value: 5}, "-15", true},
+		{&Integer{Value: math.MinInt64}, "/", &Integer{Value: -1}, "9223372036854775808", false},
+		{&Integer{Value: math.MinInt64}, "%", &Integer{Value: -1}, "0", true},
+		{&Integer{Value: -7}, "/", &Integer{Value: 2}, "-3", true},
+		{&Integer{Value: -7}, "%", &Integer{Value: 2}, "-1", true},
+		// Results that fit are demoted back to Integer
+		{&BigInt{Value: big2to63}, "-", &Integer{Value: 1}, "9223372036854775807", true},
+		{&BigInt{Value: big2to63}, "/", &BigInt{Value: big2to63}, "1", true},
+		{&BigInt{Value: big2to63}, "*", &BigInt{Value: big2to63}, "85070591730234615865843651857942052864", false},
+		{&BigInt{Value: new(big.Int).Neg(big2to63)}, "%", &Integer{Value: 10}, "-8", true},
+	}
+
+	for _, tt := range tests {
+		result, err := IntegerArithmetic(tt.operator, tt.left, tt.right)
+		if err != nil {
+			t.Errorf("%s %s %s: unexpected error %s", tt.left.Inspect(), tt.operator, tt.right.Inspect(), err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s %s %s: want %s, got %s", tt.left.Inspect(), tt.operator, tt.right.Inspect(),
+				tt.expected, result.Inspect())
+		}
+		if _, small := result.(*Integer); small != tt.small {
+			t.Errorf("%s %s %s: got %T", tt.left.Inspect(), tt.operator, tt.right.Inspect(), result)
+		}
+	}
+
+	if _, err := IntegerArithmetic("/", &BigInt{Value: big2to63}, &Integer{Value: 0}); err == nil {
+		t.Errorf("expected division by zero error")
+	}
+}
+
+func TestBigIntEqualityAndHashing(t *testing.T) {
+	a, _ := new(big.Int).SetString("100000000000000000000", 10)
+	b, _ := new(big.Int).SetString("100000000000000000000", 10)
+
+	if !Equal(&BigInt{Value: a}, &BigInt{Value: b}) {
+		t.Errorf("equal big integers compare unequal")
+	}
+	if (&BigInt{Value: a}).HashKey() != (&BigInt{Value: b}).HashKey() {
+		t.Errorf("equal big integers have different hash keys")
+	}
+	if Equal(&BigInt{Value: a}, &Integer{Value: 1}) {
+		t.Errorf("big integer equals a small one")
+	}
+	if CompareIntegers(&Integer{Value: math.MaxInt64}, &BigInt{Value: a}) != -1 {
+		t.Errorf("MaxInt64 does not compare below 1e20")
+	}
+}
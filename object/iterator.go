@@ -0,0 +1,51 @@
+package object
+
+import "fmt"
+
+// Iterator steps through an array or a hash for a for-in loop. An array
+// is read as the loop goes, so elements stored during the loop are seen;
+// a hash is iterated over the entries it had when the loop started.
+type Iterator struct {
+	array   *Array
+	entries []HashPair
+	next    int
+}
+
+// NewIterator returns an iterator over obj, which must be an array or a
+// hash
+func NewIterator(obj Object) (*Iterator, error) {
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iterator{array: obj}, nil
+	case *Hash:
+		return &Iterator{entries: obj.Entries()}, nil
+	}
+	return nil, fmt.Errorf("cannot iterate over %s", obj.Type())
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "<iterator>" }
+
+// Next returns the values of numVars loop variables for the next step,
+// or false once there are none left. Two variables take an array's index
+// and element or a hash's key and value; a single variable takes an
+// array's element or a hash's key.
+func (it *Iterator) Next(numVars int) ([]Object, bool) {
+	var vars []Object
+	if it.array != nil {
+		if it.next >= len(it.array.Elements) {
+			return nil, false
+		}
+		vars = []Object{&Integer{Value: int64(it.next)}, it.array.Elements[it.next]}
+		vars = vars[len(vars)-numVars:]
+	} else {
+		if it.next >= len(it.entries) {
+			return nil, false
+		}
+		pair := it.entries[it.next]
+		vars = []Object{pair.Key, pair.Value}
+		vars = vars[:numVars]
+	}
+	it.next++
+	return vars, true
+}
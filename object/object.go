@@ -0,0 +1,453 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/RavenStorm-bit/toy-compiler/ast"
+	"github.com/RavenStorm-bit/toy-compiler/token"
+)
+
+// ObjectType identifies the kind of a runtime value
+type ObjectType string
+
+const (
+	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
+	STRING_OBJ       = "STRING"
+	BOOLEAN_OBJ      = "BOOLEAN"
+	NULL_OBJ         = "NULL"
+	ARRAY_OBJ        = "ARRAY"
+	HASH_OBJ         = "HASH"
+	FUNCTION_OBJ     = "FUNCTION"
+	BUILTIN_OBJ      = "BUILTIN"
+	ERROR_OBJ        = "ERROR"
+	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	LOOP_CONTROL_OBJ = "LOOP_CONTROL"
+	ITERATOR_OBJ     = "ITERATOR"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
+)
+
+// Object is a runtime value
+type Object interface {
+	Type() ObjectType
+	Inspect() string
+}
+
+// Shared singletons. Booleans and null are never allocated elsewhere, so
+// they can be compared by identity.
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
+// NativeBool returns the Boolean singleton for b
+func NativeBool(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
+
+// HashKey identifies a hashable value inside a Hash
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable is implemented by values that can be used as hash keys
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+// Equal reports whether two values are equal. Numbers, strings and
+// booleans compare by value, arrays and hashes element-wise; everything
+// else compares by identity. An integer equals a float of the same value.
+func Equal(a, b Object) bool {
+	if a.Type() != b.Type() {
+		x, xok := AsFloat(a)
+		y, yok := AsFloat(b)
+		return xok && yok && x == y
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		// Integers are normalized, so an Integer never equals a BigInt
+		other, ok := b.(*Integer)
+		return ok && a.Value == other.Value
+	case *BigInt:
+		other, ok := b.(*BigInt)
+		return ok && a.Value.Cmp(other.Value) == 0
+	case *Float:
+		return a.Value == b.(*Float).Value
+	case *String:
+		return a.Value == b.(*String).Value
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+	case *Null:
+		return true
+	case *Array:
+		other := b.(*Array)
+		if len(a.Elements) != len(other.Elements) {
+			return false
+		}
+		for i, el := range a.Elements {
+			if !Equal(el, other.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		other := b.(*Hash)
+		if len(a.Pairs) != len(other.Pairs) {
+			return false
+		}
+		for key, pair := range a.Pairs {
+			otherPair, ok := other.Pairs[key]
+			if !ok || !Equal(pair.Value, otherPair.Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// Integer is a 64-bit signed integer
+type Integer struct {
+	Value int64
+}
+
+func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
+func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+// Float is a 64-bit IEEE 754 floating-point number. Floats are not
+// hashable, since NaN is not equal to itself.
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect prints the shortest decimal that reads back as the same value,
+// always with a decimal point or an exponent so it cannot be mistaken
+// for an integer
+func (f *Float) Inspect() string {
+	v := f.Value
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	if abs := math.Abs(v); abs != 0 && (abs < 1e-4 || abs >= 1e16) {
+		return strconv.FormatFloat(v, 'e', -1, 64)
+	}
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// AsFloat returns the value of an integer or Float as a float64
+func AsFloat(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value), true
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f, true
+	case *Float:
+		return obj.Value, true
+	}
+	return 0, false
+}
+
+// String is an immutable string
+type String struct {
+	Value string
+}
+
+func (s *String) Type() ObjectType { return STRING_OBJ }
+func (s *String) Inspect() string  { return s.Value }
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
+
+// Boolean is true or false
+type Boolean struct {
+	Value bool
+}
+
+func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
+func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+// Null is the absence of a value
+type Null struct{}
+
+func (n *Null) Type() ObjectType { return NULL_OBJ }
+func (n *Null) Inspect() string  { return "null" }
+
+// Array is an ordered, mutable list of values
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType { return ARRAY_OBJ }
+func (a *Array) Inspect() string {
+	elements := []string{}
+	for _, e := range a.Elements {
+		elements = append(elements, e.Inspect())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// CheckIndex reports whether index is a valid position in an array of
+// length n. Arrays do not wrap around, so negative indexes are errors.
+func CheckIndex(index int64, n int) error {
+	if index < 0 {
+		return fmt.Errorf("negative array index %d", index)
+	}
+	if index >= int64(n) {
+		return fmt.Errorf("array index %d out of range (length %d)", index, n)
+	}
+	return nil
+}
+
+// HashPair is a key and its value, kept so the original key can be
+// recovered from its HashKey
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// Hash maps hashable keys to values. It remembers the order in which
+// keys were first inserted; use Set and Delete rather than changing Pairs
+// directly so that order stays in sync.
+type Hash struct {
+	Pairs map[HashKey]HashPair
+	order []HashKey
+}
+
+// NewHash returns an empty hash
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string {
+	pairs := []string{}
+	for _, pair := range h.Entries() {
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// Get returns the value stored under key
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	pair, ok := h.Pairs[key.HashKey()]
+	return pair.Value, ok
+}
+
+// Set stores value under key. Replacing the value of an existing key
+// keeps the key's position.
+func (h *Hash) Set(key Hashable, value Object) {
+	if h.Pairs == nil {
+		h.Pairs = make(map[HashKey]HashPair)
+	}
+
+	hk := key.HashKey()
+	if _, ok := h.Pairs[hk]; !ok {
+		h.order = append(h.order, hk)
+	}
+	h.Pairs[hk] = HashPair{Key: key, Value: value}
+}
+
+// Delete removes key and returns the value it had
+func (h *Hash) Delete(key Hashable) (Object, bool) {
+	hk := key.HashKey()
+	pair, ok := h.Pairs[hk]
+	if !ok {
+		return nil, false
+	}
+
+	delete(h.Pairs, hk)
+	for i, k := range h.order {
+		if k == hk {
+			h.order = append(h.order[:i], h.order[i+1:]...)
+			break
+		}
+	}
+	return pair.Value, true
+}
+
+// Entries returns the pairs in insertion order
+func (h *Hash) Entries() []HashPair {
+	entries := make([]HashPair, 0, len(h.order))
+	for _, hk := range h.order {
+		entries = append(entries, h.Pairs[hk])
+	}
+	return entries
+}
+
+// ReturnValue wraps the value of a return statement while it unwinds
+// through enclosing blocks
+type ReturnValue struct {
+	Value Object
+}
+
+func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
+func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
+
+// LoopControl is a break or continue unwinding through enclosing blocks
+// to the innermost loop
+type LoopControl struct {
+	Continue bool
+}
+
+func (lc *LoopControl) Type() ObjectType { return LOOP_CONTROL_OBJ }
+func (lc *LoopControl) Inspect() string {
+	if lc.Continue {
+		return "continue"
+	}
+	return "break"
+}
+
+// Error is a runtime error. It propagates like a value until it reaches
+// the top of the program.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Type() ObjectType { return ERROR_OBJ }
+func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+
+// Function is a function literal closed over its defining environment
+type Function struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
+func (f *Function) Inspect() string  { return FunctionSource(f.Parameters, f.Body) }
+
+// FunctionSource is how a function is printed: its literal, rebuilt from
+// the syntax tree. Both backends print functions this way.
+func FunctionSource(parameters []*ast.Identifier, body *ast.BlockStatement) string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+// BuiltinFunction is the Go implementation of a builtin
+type BuiltinFunction func(args ...Object) Object
+
+// Builtin is a function implemented in Go
+type Builtin struct {
+	Name string
+	Fn   BuiltinFunction
+}
+
+func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
+func (b *Builtin) Inspect() string  { return "builtin function " + b.Name }
+
+// CompiledFunction is a function body compiled to its own instruction
+// stream. It lives in the constant pool and is invoked by the VM.
+type CompiledFunction struct {
+	Instructions  []byte
+	NumLocals     int
+	NumParameters int
+	Source        string    // the function as FunctionSource prints it
+	Positions     Positions // where in the source its instructions came from
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	if cf.Source != "" {
+		return cf.Source
+	}
+	// Bytecode built by hand has no source to show
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// InstructionPosition records the source position of the instruction
+// that starts at Offset
+type InstructionPosition struct {
+	Offset int
+	Pos    token.Position
+}
+
+// Positions is a position table: it maps instruction offsets to the source
+// positions they were compiled from. Entries are sorted by offset, and
+// each one covers the instructions up to the next.
+type Positions []InstructionPosition
+
+// Lookup returns the source position of the instruction containing
+// offset, if the table covers it
+func (p Positions) Lookup(offset int) (token.Position, bool) {
+	i := sort.Search(len(p), func(i int) bool { return p[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}, false
+	}
+	return p[i-1].Pos, true
+}
+
+// Closure is a compiled function together with the free variables it
+// captured when it was created. It is the VM's function value, so it has
+// the same type and printed form as a Function.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string  { return c.Fn.Inspect() }
+
+// Cell holds a variable that closures have captured. The function that
+// defined the variable and every closure that captured it share the cell,
+// so an assignment through any of them is seen by all.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return "cell(" + c.Value.Inspect() + ")" }
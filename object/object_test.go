@@ -0,0 +1,110 @@
+package object
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStringHashKey(t *testing.T) {
+	hello1 := &String{Value: "Hello World"}
+	hello2 := &String{Value: "Hello World"}
+	diff := &String{Value: "My name is johnny"}
+
+	if hello1.HashKey() != hello2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+
+	if hello1.HashKey() == diff.HashKey() {
+		t.Errorf("strings with different content have same hash keys")
+	}
+}
+
+func TestHashKeysDistinguishTypes(t *testing.T) {
+	one := &Integer{Value: 1}
+	if one.HashKey() == TRUE.HashKey() {
+		t.Errorf("1 and true have the same hash key")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Integer{Value: 2}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&Integer{Value: 1}, &String{Value: "1"}, false},
+		{&Integer{Value: 1}, &Float{Value: 1}, true},
+		{&Float{Value: 0.5}, &Integer{Value: 0}, false},
+		{&Float{Value: math.NaN()}, &Float{Value: math.NaN()}, false},
+		{TRUE, NativeBool(true), true},
+		{NULL, &Null{}, true},
+		{
+			&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "x"}}},
+			&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "x"}}},
+			true,
+		},
+		{
+			&Array{Elements: []Object{&Integer{Value: 1}}},
+			&Array{Elements: []Object{&Integer{Value: 2}}},
+			false,
+		},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("tests[%d] - Equal(%s, %s) wrong. want=%t, got=%t",
+				i, tt.a.Inspect(), tt.b.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3, "3.0"},
+		{-0.5, "-0.5"},
+		{1.0 / 3, "0.3333333333333333"},
+		{1e6, "1000000.0"},
+		{1e16, "1e+16"},
+		{1e-9, "1e-09"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("Inspect(%v): want %q, got %q", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestHashKeepsInsertionOrder(t *testing.T) {
+	h := NewHash()
+	for _, k := range []string{"c", "a", "b"} {
+		h.Set(&String{Value: k}, &Integer{Value: 1})
+	}
+	h.Set(&String{Value: "a"}, &Integer{Value: 2})
+
+	if got := h.Inspect(); got != "{c: 1, a: 2, b: 1}" {
+		t.Errorf("wrong order after update: %s", got)
+	}
+
+	if _, ok := h.Delete(&String{Value: "c"}); !ok {
+		t.Fatalf("delete of existing key reported missing")
+	}
+	if _, ok := h.Delete(&String{Value: "c"}); ok {
+		t.Fatalf("second delete reported found")
+	}
+	h.Set(&String{Value: "c"}, &Integer{Value: 3})
+
+	if got := h.Inspect(); got != "{a: 2, b: 1, c: 3}" {
+		t.Errorf("wrong order after delete and re-insert: %s", got)
+	}
+	if len(h.Pairs) != 3 {
+		t.Errorf("wrong number of pairs: %d", len(h.Pairs))
+	}
+}
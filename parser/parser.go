@@ -1,10 +1,12 @@
 package parser
 
 import (
-    "fmt"
+    "math/big"
     "strconv"
+    "strings"
 
     "github.com/RavenStorm-bit/toy-compiler/ast"
+    "github.com/RavenStorm-bit/toy-compiler/diagnostic"
     "github.com/RavenStorm-bit/toy-compiler/lexer"
     "github.com/RavenStorm-bit/toy-compiler/token"
 )
@@ -12,33 +14,63 @@ import (
 const (
     _ int = iota
     LOWEST
+    OR          // ||
+    AND         // &&
     EQUALS      // ==
-    LESSGREATER // > or <
+    LESSGREATER // >, <, >= or <=
     SUM         // +, -
-    PRODUCT     // *, /
+    PRODUCT     // *, /, %
     PREFIX      // -X
     CALL        // myFunction(X)
+    INDEX       // array[index]
 )
 
 var precedences = map[token.TokenType]int{
     token.EQ:       EQUALS,
     token.NOT_EQ:   EQUALS,
+    token.OR:       OR,
+    token.AND:      AND,
     token.LT:       LESSGREATER,
     token.GT:       LESSGREATER,
+    token.LT_EQ:    LESSGREATER,
+    token.GT_EQ:    LESSGREATER,
     token.PLUS:     SUM,
     token.MINUS:    SUM,
     token.SLASH:    PRODUCT,
     token.ASTERISK: PRODUCT,
+    token.PERCENT:  PRODUCT,
     token.LPAREN:   CALL,
+    token.LBRACKET: INDEX,
+    token.DOT:      INDEX,
 }
 
 type Parser struct {
-    l      *lexer.Lexer
-    errors []string
+    l           *lexer.Lexer
+    diagnostics diagnostic.List
+
+    // panicking is set after a syntax error and cleared once the parser
+    // has synchronized at a statement boundary. Errors reported while
+    // panicking are dropped, since they are usually caused by the first.
+    panicking bool
 
     curToken  token.Token
     peekToken token.Token
 
+    // Comments are taken out of the token stream as they are read. Doc
+    // comments directly above a token become its doc text.
+    comments []token.Token
+    curDoc   string
+    peekDoc  string
+
+    // loopDepth counts the loops enclosing the current statement within
+    // the current function, so break and continue can be checked
+    loopDepth int
+
+    // inExpression holds the break and continue statements already
+    // reported as being inside an expression, so an enclosing statement
+    // does not report them again
+    inExpression map[ast.Node]bool
+
     prefixParseFns map[token.TokenType]prefixParseFn
     infixParseFns  map[token.TokenType]infixParseFn
 }
@@ -48,30 +80,43 @@ type infixParseFn func(ast.Expression) ast.Expression
 
 func New(l *lexer.Lexer) *Parser {
     p := &Parser{
-        l:      l,
-        errors: []string{},
+        l:            l,
+        diagnostics:  diagnostic.List{},
+        inExpression: map[ast.Node]bool{},
     }
 
     p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
     p.registerPrefix(token.IDENT, p.parseIdentifier)
     p.registerPrefix(token.INT, p.parseIntegerLiteral)
+    p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
     p.registerPrefix(token.STRING, p.parseStringLiteral)
     p.registerPrefix(token.TRUE, p.parseBoolean)
     p.registerPrefix(token.FALSE, p.parseBoolean)
     p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
     p.registerPrefix(token.IF, p.parseIfExpression)
     p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+    p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
+    p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+    p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+    p.registerPrefix(token.BANG, p.parsePrefixExpression)
 
     p.infixParseFns = make(map[token.TokenType]infixParseFn)
     p.registerInfix(token.PLUS, p.parseInfixExpression)
     p.registerInfix(token.MINUS, p.parseInfixExpression)
     p.registerInfix(token.SLASH, p.parseInfixExpression)
     p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+    p.registerInfix(token.PERCENT, p.parseInfixExpression)
     p.registerInfix(token.EQ, p.parseInfixExpression)
     p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
     p.registerInfix(token.LT, p.parseInfixExpression)
     p.registerInfix(token.GT, p.parseInfixExpression)
+    p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+    p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+    p.registerInfix(token.AND, p.parseInfixExpression)
+    p.registerInfix(token.OR, p.parseInfixExpression)
     p.registerInfix(token.LPAREN, p.parseCallExpression)
+    p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+    p.registerInfix(token.DOT, p.parseMemberExpression)
 
     p.nextToken()
     p.nextToken()
@@ -88,12 +133,64 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) nextToken() {
-    p.curToken = p.peekToken
-    p.peekToken = p.l.NextToken()
+    p.curToken, p.curDoc = p.peekToken, p.peekDoc
+    p.peekToken, p.peekDoc = p.readToken()
+}
+
+// readToken returns the next token that is not a comment, along with the
+// text of the doc comments on the lines just above it. Any comment or
+// blank line in between detaches earlier doc comments, and a doc comment
+// trailing code on its line documents nothing.
+// p.peekToken still holds the previous token while this runs.
+func (p *Parser) readToken() (token.Token, string) {
+    var doc []string
+    line := 0 // line of the last doc comment
+    for {
+        tok := p.l.NextToken()
+        switch tok.Type {
+        case token.COMMENT:
+            p.comments = append(p.comments, tok)
+            doc = nil
+            continue
+        case token.DOC_COMMENT:
+            p.comments = append(p.comments, tok)
+            if tok.Span.Start.Line == p.peekToken.Span.End.Line {
+                doc, line = nil, 0
+                continue
+            }
+            if tok.Span.Start.Line != line+1 {
+                doc = nil
+            }
+            doc = append(doc, docText(tok.Literal))
+            line = tok.Span.Start.Line
+            continue
+        }
+
+        if tok.Span.Start.Line != line+1 {
+            doc = nil
+        }
+        return tok, strings.Join(doc, "\n")
+    }
+}
+
+// docText strips the /// marker and the space after it
+func docText(comment string) string {
+    text := strings.TrimPrefix(comment, "///")
+    return strings.TrimPrefix(text, " ")
 }
 
+// Diagnostics returns everything reported while parsing
+func (p *Parser) Diagnostics() diagnostic.List {
+    return p.diagnostics
+}
+
+// Errors returns the diagnostics formatted as strings
 func (p *Parser) Errors() []string {
-    return p.errors
+    errors := make([]string, len(p.diagnostics))
+    for i, d := range p.diagnostics {
+        errors[i] = d.Error()
+    }
+    return errors
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -102,30 +199,85 @@ func (p *Parser) ParseProgram() *ast.Program {
 
     for !p.curTokenIs(token.EOF) {
         stmt := p.parseStatement()
-        if stmt != nil {
+        if stmt != nil && !p.panicking {
+            p.checkLoopControl(stmt)
             program.Statements = append(program.Statements, stmt)
         }
+        p.recover()
         p.nextToken()
     }
+    program.Comments = p.comments
 
     return program
 }
 
 func (p *Parser) parseStatement() ast.Statement {
+    // The statement parsers return typed nil pointers on failure; convert
+    // those to a nil interface so callers can test for them.
     switch p.curToken.Type {
     case token.LET:
-        return p.parseLetStatement()
+        if stmt := p.parseLetStatement(); stmt != nil {
+            return stmt
+        }
     case token.WHILE:
-        return p.parseWhileStatement()
+        if stmt := p.parseWhileStatement(); stmt != nil {
+            return stmt
+        }
+    case token.FOR:
+        if p.peekTokenIs(token.LPAREN) {
+            if stmt := p.parseForStatement(); stmt != nil {
+                return stmt
+            }
+        } else if stmt := p.parseForInStatement(); stmt != nil {
+            return stmt
+        }
+    case token.BREAK:
+        if stmt := p.parseBreakStatement(); stmt != nil {
+            return stmt
+        }
+    case token.CONTINUE:
+        if stmt := p.parseContinueStatement(); stmt != nil {
+            return stmt
+        }
     case token.RETURN:
-        return p.parseReturnStatement()
+        if stmt := p.parseReturnStatement(); stmt != nil {
+            return stmt
+        }
     default:
         return p.parseExpressionStatement()
     }
+    return nil
+}
+
+// recover leaves panic mode after a syntax error by skipping tokens up to
+// the next statement boundary: a ';', a '}' closing the enclosing block,
+// or a keyword that starts a statement. The statement that failed is
+// dropped by the caller.
+func (p *Parser) recover() {
+    if !p.panicking {
+        return
+    }
+
+    for !p.curTokenIs(token.EOF) && !p.curTokenIs(token.SEMICOLON) {
+        if p.peekTokenIs(token.RBRACE) || isStatementKeyword(p.peekToken.Type) {
+            break
+        }
+        p.nextToken()
+    }
+
+    p.panicking = false
+}
+
+func isStatementKeyword(t token.TokenType) bool {
+    switch t {
+    case token.LET, token.WHILE, token.FOR, token.BREAK, token.CONTINUE, token.RETURN, token.IF:
+        return true
+    }
+    return false
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-    stmt := &ast.LetStatement{Token: p.curToken}
+    stmt := &ast.LetStatement{Token: p.curToken, Doc: p.curDoc}
 
     if !p.expectPeek(token.IDENT) {
         return nil
@@ -141,6 +293,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
     stmt.Value = p.parseExpression(LOWEST)
 
+    // Let the function know its own name so it can call itself
+    if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+        fl.Name = stmt.Name.Value
+    }
+
     if p.peekTokenIs(token.SEMICOLON) {
         p.nextToken()
     }
@@ -158,11 +315,217 @@ func (p *Parser) parseWhileStatement() *ast.WhileStatement {
         return nil
     }
 
-    stmt.Body = p.parseBlockStatement()
+    stmt.Body = p.parseLoopBody()
+
+    if p.peekTokenIs(token.SEMICOLON) {
+        p.nextToken()
+    }
 
     return stmt
 }
 
+// parseForStatement parses a C-style loop, for (init; condition; post).
+// Each clause may be left empty.
+func (p *Parser) parseForStatement() *ast.ForStatement {
+    stmt := &ast.ForStatement{Token: p.curToken}
+
+    p.nextToken()
+    p.nextToken()
+    if !p.curTokenIs(token.SEMICOLON) {
+        if p.curTokenIs(token.LET) {
+            stmt.Init = p.parseLetStatement()
+        } else {
+            stmt.Init = p.parseExpressionStatement()
+        }
+        if p.panicking {
+            return nil
+        }
+        // The init statement may already have taken its semicolon
+        if !p.curTokenIs(token.SEMICOLON) && !p.expectForSemicolon() {
+            return nil
+        }
+    }
+
+    p.nextToken()
+    if !p.curTokenIs(token.SEMICOLON) {
+        stmt.Condition = p.parseExpression(LOWEST)
+        if !p.expectPeek(token.SEMICOLON) {
+            return nil
+        }
+    }
+
+    p.nextToken()
+    if !p.curTokenIs(token.RPAREN) {
+        stmt.Post = p.parseExpressionStatement()
+        if p.panicking || !p.expectPeek(token.RPAREN) {
+            return nil
+        }
+    }
+
+    if !p.expectPeek(token.LBRACE) {
+        return nil
+    }
+
+    stmt.Body = p.parseLoopBody()
+
+    if p.peekTokenIs(token.SEMICOLON) {
+        p.nextToken()
+    }
+
+    return stmt
+}
+
+// expectForSemicolon expects the semicolon after the init clause of a
+// C-style loop, with a hint for a for-in loop written in parentheses
+func (p *Parser) expectForSemicolon() bool {
+    if p.peekTokenIs(token.IN) {
+        d := p.errorf(p.peekToken.Span, "expected next token to be %s, got %s instead",
+            token.SEMICOLON, p.peekToken.Type)
+        if d != nil {
+            d.Expected = []token.TokenType{token.SEMICOLON}
+            d.Hint = "write a for-in loop without parentheses: for x in xs { ... }"
+        }
+        return false
+    }
+    return p.expectPeek(token.SEMICOLON)
+}
+
+// parseForInStatement parses a loop over an array or hash, for x in xs
+// or for k, v in xs
+func (p *Parser) parseForInStatement() *ast.ForInStatement {
+    stmt := &ast.ForInStatement{Token: p.curToken}
+
+    for {
+        if !p.expectPeek(token.IDENT) {
+            return nil
+        }
+        stmt.Variables = append(stmt.Variables, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+        if len(stmt.Variables) == 2 || !p.peekTokenIs(token.COMMA) {
+            break
+        }
+        p.nextToken()
+    }
+
+    if !p.expectPeek(token.IN) {
+        return nil
+    }
+
+    p.nextToken()
+    stmt.Iterable = p.parseExpression(LOWEST)
+
+    if !p.expectPeek(token.LBRACE) {
+        return nil
+    }
+
+    stmt.Body = p.parseLoopBody()
+
+    if p.peekTokenIs(token.SEMICOLON) {
+        p.nextToken()
+    }
+
+    return stmt
+}
+
+// parseLoopBody parses the block of a loop, inside which break and
+// continue are allowed
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+    p.loopDepth++
+    defer func() { p.loopDepth-- }()
+    return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+    stmt := &ast.BreakStatement{Token: p.curToken}
+    if !p.checkInLoop() {
+        return nil
+    }
+    return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+    stmt := &ast.ContinueStatement{Token: p.curToken}
+    if !p.checkInLoop() {
+        return nil
+    }
+    return stmt
+}
+
+// checkInLoop reports an error if the current break or continue is not
+// inside a loop of the current function, and otherwise takes its
+// optional semicolon
+func (p *Parser) checkInLoop() bool {
+    if p.loopDepth == 0 {
+        p.errorf(p.curToken.Span, "%s outside a loop", p.curToken.Literal)
+        return false
+    }
+    if p.peekTokenIs(token.SEMICOLON) {
+        p.nextToken()
+    }
+    return true
+}
+
+// checkLoopControl reports break and continue statements whose enclosing
+// if is used as a value. Only an if that stands on its own as a statement
+// may leave the loop; anywhere else the jump would abandon an expression
+// half evaluated.
+func (p *Parser) checkLoopControl(stmt ast.Statement) {
+    switch stmt := stmt.(type) {
+    case *ast.ExpressionStatement:
+        if ifExp, ok := stmt.Expression.(*ast.IfExpression); ok {
+            p.checkIfStatement(ifExp)
+        } else {
+            p.rejectLoopControl(stmt.Expression)
+        }
+    case *ast.LetStatement:
+        p.rejectLoopControl(stmt.Value)
+    case *ast.ReturnStatement:
+        p.rejectLoopControl(stmt.ReturnValue)
+    case *ast.AssignmentStatement:
+        p.rejectLoopControl(stmt.Target)
+        p.rejectLoopControl(stmt.Value)
+    case *ast.WhileStatement:
+        p.rejectLoopControl(stmt.Condition)
+    case *ast.ForStatement:
+        p.checkLoopControl(stmt.Init)
+        p.rejectLoopControl(stmt.Condition)
+        p.checkLoopControl(stmt.Post)
+    case *ast.ForInStatement:
+        p.rejectLoopControl(stmt.Iterable)
+    }
+}
+
+// checkIfStatement checks an if used as a statement. Its branches were
+// checked statement by statement as they were parsed; an else-if chain
+// is a statement too.
+func (p *Parser) checkIfStatement(ifExp *ast.IfExpression) {
+    p.rejectLoopControl(ifExp.Condition)
+    if ifExp.Alternative != nil && ifExp.Alternative.IsElseIf() {
+        stmt := ifExp.Alternative.Statements[0].(*ast.ExpressionStatement)
+        p.checkIfStatement(stmt.Expression.(*ast.IfExpression))
+    }
+}
+
+// rejectLoopControl reports the break and continue statements in node
+// that belong to a loop outside it. The statement itself parsed fine, so
+// the error does not put the parser into panic mode.
+func (p *Parser) rejectLoopControl(node ast.Node) {
+    ast.Inspect(node, func(n ast.Node) bool {
+        switch n := n.(type) {
+        case *ast.FunctionLiteral, *ast.WhileStatement, *ast.ForStatement, *ast.ForInStatement:
+            // Their own loops, if any, are the ones they leave
+            return false
+        case *ast.BreakStatement, *ast.ContinueStatement:
+            if !p.inExpression[n] {
+                p.inExpression[n] = true
+                d := diagnostic.Errorf(token.Span{Start: n.Pos(), End: n.End()}, "%s inside an expression", n.TokenLiteral())
+                d.Hint = "only an if that is a statement on its own can leave the loop"
+                p.diagnostics = append(p.diagnostics, d)
+            }
+        }
+        return true
+    })
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
     stmt := &ast.ReturnStatement{Token: p.curToken}
 
@@ -181,15 +544,18 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 }
 
 func (p *Parser) parseExpressionStatement() ast.Statement {
-    // Check if this is an assignment expression
-    if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.ASSIGN) {
-        return p.parseAssignmentStatement()
+    if p.curTokenIs(token.INC) || p.curTokenIs(token.DEC) {
+        return p.parsePrefixIncrement()
     }
 
     stmt := &ast.ExpressionStatement{Token: p.curToken}
 
     stmt.Expression = p.parseExpression(LOWEST)
 
+    if isAssignmentOperator(p.peekToken.Type) || p.peekTokenIs(token.INC) || p.peekTokenIs(token.DEC) {
+        return p.parseAssignmentStatement(stmt.Expression)
+    }
+
     if p.peekTokenIs(token.SEMICOLON) {
         p.nextToken()
     }
@@ -197,17 +563,54 @@ func (p *Parser) parseExpressionStatement() ast.Statement {
     return stmt
 }
 
-func (p *Parser) parseAssignmentStatement() *ast.AssignmentStatement {
-    stmt := &ast.AssignmentStatement{Token: p.curToken}
+var assignmentOperators = map[token.TokenType]bool{
+    token.ASSIGN:          true,
+    token.PLUS_ASSIGN:     true,
+    token.MINUS_ASSIGN:    true,
+    token.ASTERISK_ASSIGN: true,
+    token.SLASH_ASSIGN:    true,
+    token.PERCENT_ASSIGN:  true,
+}
 
-    stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+func isAssignmentOperator(t token.TokenType) bool {
+    return assignmentOperators[t]
+}
 
-    if !p.expectPeek(token.ASSIGN) {
+// parseAssignmentStatement parses the rest of an assignment or a postfix
+// increment to target, with the operator as the peek token
+func (p *Parser) parseAssignmentStatement(target ast.Expression) ast.Statement {
+    if target == nil {
+        return nil
+    }
+    if !p.checkAssignable(target, p.peekToken) {
         return nil
     }
 
     p.nextToken()
-    stmt.Value = p.parseExpression(LOWEST)
+    stmt := &ast.AssignmentStatement{Token: p.curToken, Target: target, Operator: p.curToken.Literal}
+
+    if !p.curTokenIs(token.INC) && !p.curTokenIs(token.DEC) {
+        p.nextToken()
+        stmt.Value = p.parseExpression(LOWEST)
+    }
+
+    if p.peekTokenIs(token.SEMICOLON) {
+        p.nextToken()
+    }
+
+    return stmt
+}
+
+// parsePrefixIncrement parses ++x or --x. Increments are statements, so
+// these only appear where a statement can start.
+func (p *Parser) parsePrefixIncrement() ast.Statement {
+    stmt := &ast.AssignmentStatement{Token: p.curToken, Operator: p.curToken.Literal, Prefix: true}
+
+    p.nextToken()
+    stmt.Target = p.parseExpression(PREFIX)
+    if stmt.Target == nil || !p.checkAssignable(stmt.Target, stmt.Token) {
+        return nil
+    }
 
     if p.peekTokenIs(token.SEMICOLON) {
         p.nextToken()
@@ -216,6 +619,17 @@ func (p *Parser) parseAssignmentStatement() *ast.AssignmentStatement {
     return stmt
 }
 
+// checkAssignable reports an error at op if target is not a variable,
+// element or member
+func (p *Parser) checkAssignable(target ast.Expression, op token.Token) bool {
+    switch target.(type) {
+    case *ast.Identifier, *ast.IndexExpression, *ast.MemberExpression:
+        return true
+    }
+    p.errorf(op.Span, "cannot assign to %s", target.String())
+    return false
+}
+
 func (p *Parser) ParseExpression() ast.Expression {
     return p.parseExpression(LOWEST)
 }
@@ -245,10 +659,43 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
     lit := &ast.IntegerLiteral{Token: p.curToken}
 
-    value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+    literal := p.curToken.Literal
+    // strconv would read a leading zero as an octal prefix
+    if digits := strings.ReplaceAll(literal, "_", ""); len(digits) > 1 && digits[0] == '0' && isDigit(digits[1]) {
+        d := p.errorf(p.curToken.Span, "invalid integer literal %s", literal)
+        if d != nil {
+            d.Hint = "write octal numbers as 0o" + strings.TrimLeft(literal, "0_")
+        }
+        return nil
+    }
+
+    value, err := strconv.ParseInt(literal, 0, 64)
+    if err == nil {
+        lit.Value = value
+        return lit
+    }
+
+    // Too big for 64 bits, but any size is allowed
+    if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+        if n, ok := new(big.Int).SetString(literal, 0); ok {
+            lit.Big = n
+            return lit
+        }
+    }
+    p.errorf(p.curToken.Span, "invalid integer literal %s", literal)
+    return nil
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+    lit := &ast.FloatLiteral{Token: p.curToken}
+
+    value, err := strconv.ParseFloat(p.curToken.Literal, 64)
     if err != nil {
-        msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-        p.errors = append(p.errors, msg)
+        if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+            p.errorf(p.curToken.Span, "float literal %s out of range", p.curToken.Literal)
+        } else {
+            p.errorf(p.curToken.Span, "invalid float literal %s", p.curToken.Literal)
+        }
         return nil
     }
 
@@ -256,6 +703,22 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
     return lit
 }
 
+func isDigit(ch byte) bool {
+    return '0' <= ch && ch <= '9'
+}
+
+func (p *Parser) parsePrefixExpression() ast.Expression {
+    expression := &ast.PrefixExpression{
+        Token:    p.curToken,
+        Operator: p.curToken.Literal,
+    }
+
+    p.nextToken()
+    expression.Right = p.parseExpression(PREFIX)
+
+    return expression
+}
+
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
     expression := &ast.InfixExpression{
         Token:    p.curToken,
@@ -289,7 +752,22 @@ func (p *Parser) parseIdentifier() ast.Expression {
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
-    return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+    value, err := lexer.Unquote(p.curToken.Literal)
+    if err != nil {
+        // Point at the escape itself; double-quoted strings never span
+        // lines, so it sits on the token's line.
+        span := p.curToken.Span
+        if e, ok := err.(*lexer.EscapeError); ok {
+            span.Start.Offset += e.Offset
+            span.Start.Column += e.Offset
+            span.End = span.Start
+            span.End.Offset += e.Length
+            span.End.Column += e.Length
+        }
+        p.errorf(span, "%s", err)
+        return nil
+    }
+    return &ast.StringLiteral{Token: p.curToken, Value: value}
 }
 
 func (p *Parser) parseBoolean() ast.Expression {
@@ -319,6 +797,12 @@ func (p *Parser) parseIfExpression() ast.Expression {
     
     if p.peekTokenIs(token.ELSE) {
         p.nextToken()
+
+        if p.peekTokenIs(token.IF) {
+            p.nextToken()
+            expression.Alternative = p.parseElseIf()
+            return expression
+        }
         
         if !p.expectPeek(token.LBRACE) {
             return nil
@@ -330,6 +814,19 @@ func (p *Parser) parseIfExpression() ast.Expression {
     return expression
 }
 
+// parseElseIf parses the if after an else. An else-if is sugar for an
+// else block holding just the nested if; the block has no braces of its
+// own, so its Rbrace is left empty.
+func (p *Parser) parseElseIf() *ast.BlockStatement {
+    tok := p.curToken
+    nested := p.parseIfExpression()
+    if nested == nil {
+        return nil
+    }
+    stmt := &ast.ExpressionStatement{Token: tok, Expression: nested}
+    return &ast.BlockStatement{Token: tok, Statements: []ast.Statement{stmt}}
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
     block := &ast.BlockStatement{Token: p.curToken}
     block.Statements = []ast.Statement{}
@@ -338,11 +835,23 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
     
     for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
         stmt := p.parseStatement()
-        if stmt != nil {
+        if stmt != nil && !p.panicking {
+            p.checkLoopControl(stmt)
             block.Statements = append(block.Statements, stmt)
         }
+        p.recover()
         p.nextToken()
     }
+
+    if p.curTokenIs(token.RBRACE) {
+        block.Rbrace = p.curToken
+    } else {
+        d := p.errorf(p.curToken.Span, "expected } to close block, got %s", p.curToken.Type)
+        if d != nil {
+            d.Expected = []token.TokenType{token.RBRACE}
+            d.Hint = "the block opened at " + block.Token.Span.Start.String() + " is never closed"
+        }
+    }
     
     return block
 }
@@ -360,7 +869,11 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
         return nil
     }
     
+    // A function body is outside any loop around the literal
+    loopDepth := p.loopDepth
+    p.loopDepth = 0
     lit.Body = p.parseBlockStatement()
+    p.loopDepth = loopDepth
     
     return lit
 }
@@ -394,37 +907,147 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
     exp := &ast.CallExpression{Token: p.curToken, Function: function}
-    exp.Arguments = p.parseCallArguments()
+    exp.Arguments = p.parseExpressionList(token.RPAREN)
+    if p.curTokenIs(token.RPAREN) {
+        exp.Rparen = p.curToken
+    }
     return exp
 }
 
-func (p *Parser) parseCallArguments() []ast.Expression {
-    args := []ast.Expression{}
+func (p *Parser) parseArrayLiteral() ast.Expression {
+    array := &ast.ArrayLiteral{Token: p.curToken}
+    array.Elements = p.parseExpressionList(token.RBRACKET)
+    if p.curTokenIs(token.RBRACKET) {
+        array.Rbracket = p.curToken
+    }
+    return array
+}
+
+func (p *Parser) parseHashLiteral() ast.Expression {
+    hash := &ast.HashLiteral{Token: p.curToken}
+
+    for !p.peekTokenIs(token.RBRACE) {
+        p.nextToken()
+        key := p.parseExpression(LOWEST)
+
+        if !p.expectPeek(token.COLON) {
+            return nil
+        }
 
-    if p.peekTokenIs(token.RPAREN) {
         p.nextToken()
-        return args
+        value := p.parseExpression(LOWEST)
+
+        hash.Keys = append(hash.Keys, key)
+        hash.Values = append(hash.Values, value)
+
+        if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+            return nil
+        }
     }
 
     p.nextToken()
-    args = append(args, p.parseExpression(LOWEST))
+    hash.Rbrace = p.curToken
+
+    return hash
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+    exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+
+    p.nextToken()
+    exp.Index = p.parseExpression(LOWEST)
+
+    if !p.expectPeek(token.RBRACKET) {
+        return nil
+    }
+    exp.Rbracket = p.curToken
+
+    return exp
+}
+
+func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
+    exp := &ast.MemberExpression{Token: p.curToken, Object: object}
+
+    if !p.expectPeek(token.IDENT) {
+        return nil
+    }
+    exp.Property = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+    return exp
+}
+
+// parseExpressionList parses comma-separated expressions up to and
+// including the end token
+func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+    list := []ast.Expression{}
+
+    if p.peekTokenIs(end) {
+        p.nextToken()
+        return list
+    }
+
+    p.nextToken()
+    list = append(list, p.parseExpression(LOWEST))
 
     for p.peekTokenIs(token.COMMA) {
         p.nextToken()
         p.nextToken()
-        args = append(args, p.parseExpression(LOWEST))
+        list = append(list, p.parseExpression(LOWEST))
     }
 
-    if !p.expectPeek(token.RPAREN) {
+    if !p.expectPeek(end) {
         return nil
     }
 
-    return args
+    return list
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-    msg := fmt.Sprintf("no prefix parse function for %s found", t)
-    p.errors = append(p.errors, msg)
+    if t == token.ILLEGAL && p.curToken.Literal == "/*" {
+        d := p.errorf(p.curToken.Span, "unterminated block comment")
+        if d != nil {
+            d.Hint = "block comments nest; each /* needs its own */"
+        }
+        return
+    }
+    if t == token.ILLEGAL && isUnterminatedString(p.curToken.Literal) {
+        d := p.errorf(p.curToken.Span, "unterminated string")
+        if d != nil && p.curToken.Literal[0] == '"' {
+            d.Hint = "use \\n for a line break, or a `raw string` to span lines"
+        }
+        return
+    }
+    if t == token.ILLEGAL {
+        p.errorf(p.curToken.Span, "illegal character %q", p.curToken.Literal)
+        return
+    }
+
+    d := p.errorf(p.curToken.Span, "expected an expression, got %s", t)
+    if d == nil {
+        return
+    }
+    switch t {
+    case token.RPAREN, token.RBRACE, token.RBRACKET:
+        d.Hint = "unmatched " + string(t)
+    case token.ASSIGN:
+        d.Hint = "use == to compare values"
+    case token.INC, token.DEC:
+        d.Hint = p.curToken.Literal + " is a statement and cannot be used inside an expression"
+    }
+}
+
+// errorf reports a syntax error at span and enters panic mode. It returns
+// the new diagnostic so callers can fill in Expected and Hint, or nil when
+// the error was suppressed because the parser is already panicking.
+func (p *Parser) errorf(span token.Span, format string, args ...interface{}) *diagnostic.Diagnostic {
+    if p.panicking {
+        return nil
+    }
+    p.panicking = true
+
+    d := diagnostic.Errorf(span, format, args...)
+    p.diagnostics = append(p.diagnostics, d)
+    return d
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -446,7 +1069,25 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-    msg := fmt.Sprintf("expected next token to be %s, got %s instead",
+    d := p.errorf(p.peekToken.Span, "expected next token to be %s, got %s instead",
         t, p.peekToken.Type)
-    p.errors = append(p.errors, msg)
+    if d == nil {
+        return
+    }
+    d.Expected = []token.TokenType{t}
+    if p.peekTokenIs(token.EOF) {
+        d.Hint = "the input ended early; check for an unclosed ( or {"
+    } else if p.peekTokenIs(token.ILLEGAL) && p.peekToken.Literal == "/*" {
+        d.Hint = "unterminated block comment"
+    } else if p.peekTokenIs(token.ILLEGAL) && isUnterminatedString(p.peekToken.Literal) {
+        d.Hint = "unterminated string"
+    } else if p.peekTokenIs(token.INC) || p.peekTokenIs(token.DEC) {
+        d.Hint = p.peekToken.Literal + " is a statement and cannot be used inside an expression"
+    }
+}
+
+// isUnterminatedString reports whether an ILLEGAL token's literal is the
+// start of a string the lexer could not find the end of
+func isUnterminatedString(literal string) bool {
+    return strings.HasPrefix(literal, `"`) || strings.HasPrefix(literal, "`")
 }
\ No newline at end of file
@@ -0,0 +1,462 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// maxHistory is how many lines of history are kept
+const maxHistory = 1000
+
+// errInterrupt is returned by readLine when Ctrl-C abandons the line
+var errInterrupt = errors.New("interrupted")
+
+// lineReader reads the REPL's input one line at a time, showing prompt
+// first. It returns io.EOF when the input ends.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// scanReader reads plain lines, for input that is not a terminal
+type scanReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scanReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// newLineReader returns an editor when in and out are both a terminal,
+// and a scanReader otherwise
+func newLineReader(in io.Reader, out io.Writer, complete func(prefix string) []string) lineReader {
+	inFile, ok := in.(*os.File)
+	outFile, ok2 := out.(*os.File)
+	if !ok || !ok2 || !isTerminal(int(inFile.Fd())) || !isTerminal(int(outFile.Fd())) {
+		return &scanReader{scanner: bufio.NewScanner(in), out: out}
+	}
+
+	e := newEditor(in, out, complete)
+	e.raw = func() (func(), error) { return makeRaw(int(inFile.Fd())) }
+	e.loadHistory(historyPath())
+	return e
+}
+
+// historyPath is $TOY_HISTORY, or ~/.toy_history when that is unset. An
+// empty $TOY_HISTORY turns the history file off.
+func historyPath() string {
+	if path, ok := os.LookupEnv("TOY_HISTORY"); ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".toy_history")
+}
+
+// Keys that arrive as escape sequences. Other keys are the rune they
+// produce, with control keys as their ASCII control codes.
+const (
+	keyUp rune = -(iota + 1)
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDelete
+	keyEscape
+	keyUnknown
+)
+
+func ctrl(c rune) rune {
+	return c & 0x1f
+}
+
+const (
+	keyTab       = '\t'
+	keyEnter     = '\r'
+	keyBackspace = 127
+)
+
+// editor is a line editor for a terminal in raw mode. It supports cursor
+// movement, history navigation, reverse search with Ctrl-R and
+// completion with Tab.
+type editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	raw      func() (func(), error) // switches to raw mode and returns a restore func, if set
+	complete func(prefix string) []string
+
+	history     []string
+	historyFile string // lines are appended here as they are entered
+
+	prompt    string
+	buf       []rune
+	pos       int    // cursor position in buf
+	histIndex int    // the history entry shown; len(history) for the new line
+	saved     []rune // the new line, while browsing history
+}
+
+func newEditor(in io.Reader, out io.Writer, complete func(prefix string) []string) *editor {
+	return &editor{in: bufio.NewReader(in), out: out, complete: complete}
+}
+
+// loadHistory reads the history saved in path and makes the editor
+// append to it. A missing file starts an empty history.
+func (e *editor) loadHistory(path string) {
+	if path == "" {
+		return
+	}
+	e.historyFile = path
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+		ioutil.WriteFile(path, []byte(strings.Join(e.history, "\n")+"\n"), 0600)
+	}
+}
+
+// addHistory records an entered line, unless it is blank or repeats the
+// previous one. The history file is best effort: failing to write it is
+// not worth interrupting the session for.
+func (e *editor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, line)
+	f.Close()
+}
+
+func (e *editor) readLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	e.prompt, e.buf, e.pos = prompt, nil, 0
+	e.histIndex, e.saved = len(e.history), nil
+	e.refresh()
+
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+		if key == ctrl('r') {
+			// The key that ends the search is handled as usual
+			if key, err = e.search(); err != nil {
+				return "", err
+			}
+		}
+
+		switch key {
+		case keyEnter, '\n':
+			fmt.Fprint(e.out, "\r\n")
+			line := string(e.buf)
+			e.addHistory(line)
+			return line, nil
+		case ctrl('c'):
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case ctrl('d'):
+			if len(e.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.deleteRange(e.pos, e.pos+1)
+		case keyDelete:
+			e.deleteRange(e.pos, e.pos+1)
+		case keyBackspace, ctrl('h'):
+			e.deleteRange(e.pos-1, e.pos)
+		case keyLeft, ctrl('b'):
+			e.moveTo(e.pos - 1)
+		case keyRight, ctrl('f'):
+			e.moveTo(e.pos + 1)
+		case keyHome, ctrl('a'):
+			e.moveTo(0)
+		case keyEnd, ctrl('e'):
+			e.moveTo(len(e.buf))
+		case ctrl('k'):
+			e.deleteRange(e.pos, len(e.buf))
+		case ctrl('u'):
+			e.deleteRange(0, e.pos)
+		case ctrl('w'):
+			start := e.pos
+			for start > 0 && e.buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.buf[start-1] != ' ' {
+				start--
+			}
+			e.deleteRange(start, e.pos)
+		case ctrl('l'):
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case keyUp, ctrl('p'):
+			e.showHistory(e.histIndex - 1)
+		case keyDown, ctrl('n'):
+			e.showHistory(e.histIndex + 1)
+		case keyTab:
+			e.completeWord()
+		default:
+			if unicode.IsPrint(key) {
+				e.insert([]rune{key})
+			}
+		}
+		e.refresh()
+	}
+}
+
+// readKey reads one key press, decoding the escape sequences terminals
+// send for arrows and other special keys
+func (e *editor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != 27 {
+		return r, err
+	}
+
+	next, _, err := e.in.ReadRune()
+	if err != nil {
+		return keyEscape, nil
+	}
+	if next != '[' && next != 'O' {
+		e.in.UnreadRune()
+		return keyEscape, nil
+	}
+
+	// A CSI sequence is parameter bytes followed by a final byte
+	var params []rune
+	for {
+		c, _, err := e.in.ReadRune()
+		if err != nil {
+			return keyUnknown, nil
+		}
+		if c >= 0x40 && c <= 0x7e {
+			return decodeSequence(string(params), c), nil
+		}
+		params = append(params, c)
+	}
+}
+
+func decodeSequence(params string, final rune) rune {
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome
+		case "4", "8":
+			return keyEnd
+		case "3":
+			return keyDelete
+		}
+	}
+	return keyUnknown
+}
+
+// refresh redraws the line and puts the cursor back in place
+func (e *editor) refresh() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(e.buf))
+	if back := len(e.buf) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (e *editor) insert(text []rune) {
+	buf := make([]rune, 0, len(e.buf)+len(text))
+	buf = append(buf, e.buf[:e.pos]...)
+	buf = append(buf, text...)
+	e.buf = append(buf, e.buf[e.pos:]...)
+	e.pos += len(text)
+}
+
+// deleteRange removes buf[start:end], clamped to the line, and leaves
+// the cursor at start
+func (e *editor) deleteRange(start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(e.buf) {
+		end = len(e.buf)
+	}
+	if start >= end {
+		return
+	}
+	e.buf = append(e.buf[:start:start], e.buf[end:]...)
+	e.pos = start
+}
+
+func (e *editor) moveTo(pos int) {
+	if pos >= 0 && pos <= len(e.buf) {
+		e.pos = pos
+	}
+}
+
+// showHistory replaces the line with history entry i. Moving past the
+// newest entry returns to the line that was being typed.
+func (e *editor) showHistory(i int) {
+	if i < 0 || i > len(e.history) || i == e.histIndex {
+		return
+	}
+	if e.histIndex == len(e.history) {
+		e.saved = e.buf
+	}
+
+	e.histIndex = i
+	if i == len(e.history) {
+		e.buf = e.saved
+	} else {
+		e.buf = []rune(e.history[i])
+	}
+	e.pos = len(e.buf)
+}
+
+// search runs a reverse incremental search through the history. Typing
+// narrows the search, Ctrl-R finds an older match, and Ctrl-G or Escape
+// gives up and restores the line. Any other key accepts the match into
+// the line and is returned for the caller to handle; 0 means none.
+func (e *editor) search() (rune, error) {
+	savedBuf, savedPos := e.buf, e.pos
+	var query []rune
+	match := len(e.history)
+	failed := false
+
+	find := func(from int) {
+		for i := from; i >= 0; i-- {
+			if i < len(e.history) && strings.Contains(e.history[i], string(query)) {
+				match, failed = i, false
+				e.buf = []rune(e.history[i])
+				e.pos = len([]rune(e.history[i][:strings.Index(e.history[i], string(query))]))
+				return
+			}
+		}
+		failed = true
+	}
+
+	for {
+		label := "reverse-i-search"
+		if failed {
+			label = "failed-" + label
+		}
+		fmt.Fprintf(e.out, "\r(%s)`%s': %s\x1b[K", label, string(query), string(e.buf))
+
+		key, err := e.readKey()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case key == ctrl('r'):
+			find(match - 1)
+		case key == keyBackspace || key == ctrl('h'):
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history) - 1)
+			}
+		case key == ctrl('g') || key == keyEscape:
+			e.buf, e.pos = savedBuf, savedPos
+			return 0, nil
+		case key > 0 && unicode.IsPrint(key):
+			query = append(query, key)
+			find(match)
+		default:
+			return key, nil
+		}
+	}
+}
+
+// completeWord completes the identifier before the cursor. A single
+// candidate is filled in; several are extended to their common prefix,
+// or listed below the line when that adds nothing.
+func (e *editor) completeWord() {
+	if e.complete == nil {
+		return
+	}
+	start := e.pos
+	for start > 0 && isIdentRune(e.buf[start-1]) {
+		start--
+	}
+	prefix := string(e.buf[start:e.pos])
+	if prefix == "" {
+		return
+	}
+
+	candidates := e.complete(prefix)
+	switch len(candidates) {
+	case 0:
+		fmt.Fprint(e.out, "\a")
+	case 1:
+		e.insert([]rune(candidates[0][len(prefix):]))
+	default:
+		common := commonPrefix(candidates)
+		if len(common) > len(prefix) {
+			e.insert([]rune(common[len(prefix):]))
+			return
+		}
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,182 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/runner"
+)
+
+const (
+	up    = "\x1b[A"
+	down  = "\x1b[B"
+	right = "\x1b[C"
+	left  = "\x1b[D"
+	home  = "\x1b[H"
+	end   = "\x1b[F"
+	del   = "\x1b[3~"
+	bs    = "\x7f"
+	enter = "\r"
+)
+
+// readLines types keys into an editor and returns the lines it read
+// until the keys ran out
+func readLines(e *editor, keys string) []string {
+	e.in.Reset(strings.NewReader(keys))
+	var lines []string
+	for {
+		line, err := e.readLine(">> ")
+		if err == io.EOF {
+			return lines
+		}
+		if err == errInterrupt {
+			line = "<interrupt>"
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestEditorEditing(t *testing.T) {
+	tests := []struct {
+		keys     string
+		expected []string
+	}{
+		{"let x = 1" + enter, []string{"let x = 1"}},
+		{"ac" + left + "b" + enter, []string{"abc"}},
+		{"bc" + home + "a" + end + "d" + enter, []string{"abcd"}},
+		{"abc\x02\x02\x06X\x01Y\x05Z" + enter, []string{"YabXcZ"}},
+		{"abcd" + left + left + bs + del + enter, []string{"ad"}},
+		{"abc" + left + "\x04" + enter, []string{"ab"}},
+		{"abc def" + left + left + "\x0b" + enter, []string{"abc d"}},
+		{"abc def" + left + left + "\x15" + enter, []string{"ef"}},
+		{"let foo = bar  \x17" + enter, []string{"let foo = "}},
+		{"oops\x03fine" + enter, []string{"<interrupt>", "fine"}},
+		{"a\x1b[5~b" + enter, []string{"ab"}},
+		{"é√" + left + "ü" + enter, []string{"éü√"}},
+		{"x" + enter + "\x04", []string{"x"}},
+	}
+
+	for _, tt := range tests {
+		e := newEditor(nil, ioutil.Discard, nil)
+		got := readLines(e, tt.keys)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: want %q, got %q", tt.keys, tt.expected, got)
+		}
+	}
+}
+
+func TestEditorHistory(t *testing.T) {
+	e := newEditor(nil, ioutil.Discard, nil)
+	readLines(e, "one"+enter+"two"+enter+enter+"two"+enter)
+	if want := []string{"one", "two"}; !reflect.DeepEqual(e.history, want) {
+		t.Fatalf("blank and repeated lines recorded. want %q, got %q", want, e.history)
+	}
+
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{up + enter, "two"},
+		{up + up + up + up + enter, "one"},
+		{up + up + down + enter, "two"},
+		{"draft" + up + down + enter, "draft"},
+		{up + "!" + enter, "two!"},
+		{"\x12n" + enter, "one"},
+		{"\x12o" + "\x12" + enter, "one"},
+		{"\x12tw" + bs + bs + "ne" + right + "X" + enter, "onXe"},
+		{"keep\x12zzz\x07" + enter, "keep"},
+		{"\x12two\x01>" + enter, ">two"},
+	}
+
+	for _, tt := range tests {
+		history := append([]string(nil), e.history...)
+		got := readLines(e, tt.keys)
+		e.history = history
+		if len(got) != 1 || got[0] != tt.expected {
+			t.Errorf("%q: want %q, got %q", tt.keys, tt.expected, got)
+		}
+	}
+}
+
+func TestEditorHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+
+	e := newEditor(nil, ioutil.Discard, nil)
+	e.loadHistory(path)
+	readLines(e, "let a = 1"+enter+"a + 1"+enter)
+
+	e = newEditor(nil, ioutil.Discard, nil)
+	e.loadHistory(path)
+	if got := readLines(e, up+up+enter); len(got) != 1 || got[0] != "let a = 1" {
+		t.Errorf("history not restored from file. got %q", got)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "let a = 1\na + 1\nlet a = 1\n" {
+		t.Errorf("wrong history file %q", data)
+	}
+}
+
+func TestEditorCompletion(t *testing.T) {
+	words := []string{"let", "len", "length", "print", "push"}
+	complete := func(prefix string) []string {
+		var out []string
+		for _, w := range words {
+			if strings.HasPrefix(w, prefix) {
+				out = append(out, w)
+			}
+		}
+		return out
+	}
+
+	tests := []struct {
+		keys     string
+		expected string
+		listed   string
+	}{
+		{"pri\t(1)" + enter, "print(1)", ""},
+		{"x = le\t" + enter, "x = le", "let  len  length"},
+		{"lengt\t" + enter, "length", ""},
+		{"p\t" + enter, "p", "print  push"},
+		{"pu\t" + enter, "push", ""},
+		{"zz\t" + enter, "zz", ""},
+		{"\t" + enter, "", ""},
+		{"(pr\t" + left + "X" + enter, "(prinXt", ""},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		e := newEditor(nil, &out, complete)
+		got := readLines(e, tt.keys)
+		if len(got) != 1 || got[0] != tt.expected {
+			t.Errorf("%q: want %q, got %q", tt.keys, tt.expected, got)
+		}
+		if tt.listed != "" && !strings.Contains(out.String(), "\r\n"+tt.listed+"\r\n") {
+			t.Errorf("%q: candidates %q not listed in %q", tt.keys, tt.listed, out.String())
+		}
+	}
+}
+
+func TestSessionComplete(t *testing.T) {
+	for _, backend := range []runner.Backend{runner.BackendVM, runner.BackendEval} {
+		s := &session{out: ioutil.Discard, backend: backend}
+		s.reset("")
+		s.run("let total = 0; let tally = 1; for t in [1] { total += t }")
+
+		if got, want := s.complete("ta"), []string{"tally"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %q, got %q", backend, want, got)
+		}
+		// The loop's hidden iterator is not offered
+		if got, want := s.complete("for"), []string{"for"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %q, got %q", backend, want, got)
+		}
+		if got, want := s.complete("le"), []string{"len", "let"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %q, got %q", backend, want, got)
+		}
+	}
+}
@@ -1,51 +1,337 @@
 package repl
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/RavenStorm-bit/toy-compiler/ast"
+	"github.com/RavenStorm-bit/toy-compiler/compiler"
 	"github.com/RavenStorm-bit/toy-compiler/evaluator"
 	"github.com/RavenStorm-bit/toy-compiler/lexer"
-	"github.com/RavenStorm-bit/toy-compiler/parser"
+	"github.com/RavenStorm-bit/toy-compiler/object"
+	"github.com/RavenStorm-bit/toy-compiler/runner"
+	"github.com/RavenStorm-bit/toy-compiler/stdlib"
+	"github.com/RavenStorm-bit/toy-compiler/token"
+	"github.com/RavenStorm-bit/toy-compiler/vm"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// CONTINUE_PROMPT is shown while an input is incomplete, such as a
+// function whose closing brace has not been typed yet
+const CONTINUE_PROMPT = ".. "
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(s *session, arg string)
+}
+
+// commands are the REPL's own commands, which start with a colon
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{":help", "", "list the commands", (*session).help},
+		{":tokens", "<code>", "print the tokens of code", (*session).tokens},
+		{":ast", "<code>", "print the syntax tree of code", (*session).ast},
+		{":bytecode", "<code>", "disassemble code as the session would compile it", (*session).bytecode},
+		{":load", "<file>", "run a file in the session", (*session).load},
+		{":reset", "", "forget every definition", (*session).reset},
+		{":backend", "[vm|eval]", "show the backend, or switch and reset", (*session).switchBackend},
+		{":quit", "", "end the session", nil},
+	}
+}
+
+func lookup(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
 
+// session is the state kept between inputs. The VM backend keeps the
+// compiler's symbol table and constants and the VM's globals; the eval
+// backend keeps an environment.
+type session struct {
+	out     io.Writer
+	backend runner.Backend
+
+	symbols   *compiler.SymbolTable
+	constants []object.Object
+	globals   []object.Object
+
+	env *object.Environment
+}
+
+// Start runs an interactive session on backend, which is the VM when
+// empty. Definitions persist from one input to the next, and an input
+// with unclosed brackets continues on the following lines. On a terminal
+// lines can be edited, recalled from a history file and completed with
+// Tab; other input is read as plain lines.
+func Start(in io.Reader, out io.Writer, backend runner.Backend) {
+	if backend == "" {
+		backend = runner.BackendVM
+	}
+	s := &session{out: out, backend: backend}
+	s.reset("")
+
+	lines := newLineReader(in, out, s.complete)
 	for {
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		input, err := readInput(lines)
+		if err == errInterrupt {
+			continue
+		}
+		if err != nil {
 			return
 		}
 
-		line := scanner.Text()
-		if line == "exit" || line == "quit" {
-			fmt.Println("Goodbye!")
+		line := strings.TrimSpace(input)
+		switch {
+		case line == "":
+			continue
+		case line == "exit" || line == "quit" || line == ":quit":
+			fmt.Fprintln(out, "Goodbye!")
 			return
+		case strings.HasPrefix(line, ":"):
+			s.command(line)
+		default:
+			s.run(input)
+		}
+	}
+}
+
+// readInput reads lines until they form a complete input
+func readInput(lines lineReader) (string, error) {
+	input, err := lines.readLine(PROMPT)
+	for err == nil && incomplete(input) {
+		var line string
+		line, err = lines.readLine(CONTINUE_PROMPT)
+		input += "\n" + line
+	}
+	return input, err
+}
+
+// incomplete reports whether input stops inside brackets, a raw string
+// or a block comment, so the next line should be appended to it
+func incomplete(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+	for {
+		tok := l.NextToken()
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.ILLEGAL:
+			if tok.Literal == "/*" || strings.HasPrefix(tok.Literal, "`") {
+				return true
+			}
+		case token.EOF:
+			return depth > 0
 		}
+	}
+}
 
-		l := lexer.New(line)
-		p := parser.New(l)
-		expression := p.ParseExpression()
+func (s *session) command(line string) {
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t\n"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i:])
+	}
 
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
+	cmd := lookup(name)
+	if cmd == nil {
+		fmt.Fprintf(s.out, "unknown command %s; :help lists them\n", name)
+		return
+	}
+	if strings.HasPrefix(cmd.usage, "<") && arg == "" {
+		fmt.Fprintf(s.out, "usage: %s %s\n", name, cmd.usage)
+		return
+	}
+	cmd.run(s, arg)
+}
+
+func (s *session) help(string) {
+	for _, c := range commands {
+		fmt.Fprintf(s.out, "  %-18s %s\n", strings.TrimSpace(c.name+" "+c.usage), c.summary)
+	}
+}
+
+func (s *session) tokens(code string) {
+	l := lexer.New(code)
+	l.SetEmitComments(true)
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			return
 		}
+		fmt.Fprintf(s.out, "%-8s %-10s %q\n", tok.Span.Start, tok.Type, tok.Literal)
+	}
+}
+
+func (s *session) ast(code string) {
+	program, err := runner.ParseSource("", code)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+	ast.Fprint(s.out, program)
+}
+
+// bytecode compiles code against a copy of the session's symbols, so
+// its definitions are not kept
+func (s *session) bytecode(code string) {
+	if s.backend != runner.BackendVM {
+		fmt.Fprintln(s.out, ":bytecode requires the vm backend")
+		return
+	}
+	program, err := runner.ParseSource("", code)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+	comp := compiler.NewWithState(s.symbols.Copy(), s.constants)
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(s.out, "compiler errors:\n%s\n", err)
+		return
+	}
+	fmt.Fprint(s.out, comp.Bytecode().Disassemble())
+}
+
+func (s *session) load(filename string) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(s.out, "could not read file %s: %s\n", filename, err)
+		return
+	}
+	s.runFile(filename, string(data))
+}
+
+func (s *session) reset(string) {
+	s.symbols = compiler.NewGlobalSymbolTable()
+	s.constants = []object.Object{}
+	s.globals = make([]object.Object, vm.GlobalsSize)
+	s.env = object.NewEnvironment()
+}
+
+func (s *session) switchBackend(name string) {
+	switch runner.Backend(name) {
+	case "":
+		fmt.Fprintln(s.out, s.backend)
+	case runner.BackendVM, runner.BackendEval:
+		s.backend = runner.Backend(name)
+		s.reset("")
+		fmt.Fprintf(s.out, "switched to the %s backend\n", name)
+	default:
+		fmt.Fprintf(s.out, "unknown backend %q (want vm or eval)\n", name)
+	}
+}
+
+func (s *session) run(source string) {
+	s.runFile("", source)
+}
+
+// runFile runs source in the session and prints its result. filename is
+// only used in messages.
+func (s *session) runFile(filename, source string) {
+	program, err := runner.ParseSource(filename, source)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
 
-		if expression != nil {
-			result := evaluator.Eval(expression)
-			fmt.Fprintf(out, "%d\n", result)
+	var result object.Object
+	if s.backend == runner.BackendEval {
+		result, err = s.eval(program)
+	} else {
+		result, err = s.runVM(program)
+	}
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+
+	if !isQuiet(program, result) {
+		fmt.Fprintln(s.out, result.Inspect())
+	}
+}
+
+func (s *session) runVM(program *ast.Program) (object.Object, error) {
+	// Definitions from input that does not compile are thrown away
+	symbols := s.symbols.Copy()
+	comp := compiler.NewWithState(symbols, s.constants)
+	if err := comp.Compile(program); err != nil {
+		return nil, &runner.CompileError{Err: fmt.Errorf("compiler errors:\n%w", err)}
+	}
+	bc := comp.Bytecode()
+	s.symbols, s.constants = symbols, bc.Constants
+
+	// A runtime error can skip a let that was compiled; the VM reads its
+	// unset variable as null
+	machine := vm.NewWithGlobals(bc, s.globals)
+	if err := machine.Run(); err != nil {
+		return nil, &runner.RuntimeError{Err: err}
+	}
+	return machine.Result(), nil
+}
+
+func (s *session) eval(program *ast.Program) (object.Object, error) {
+	result := evaluator.Eval(program, s.env)
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, &runner.RuntimeError{Err: errors.New(errObj.Message)}
+	}
+	if result == nil {
+		result = object.NULL
+	}
+	return result, nil
+}
+
+// complete returns the keywords, builtins and globals that start with
+// prefix, sorted
+func (s *session) complete(prefix string) []string {
+	var globals []string
+	if s.backend == runner.BackendEval {
+		globals = s.env.Names()
+	} else {
+		globals = s.symbols.Names()
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	add := func(name string) {
+		// The compiler's hidden loop variables are not identifiers
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name, ".") && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
 		}
 	}
+	for _, k := range token.Keywords() {
+		add(k)
+	}
+	for _, b := range stdlib.Builtins {
+		add(b.Name)
+	}
+	for _, g := range globals {
+		add(g)
+	}
+	sort.Strings(names)
+	return names
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	fmt.Fprintf(out, "Parser errors:\n")
-	for _, msg := range errors {
-		fmt.Fprintf(out, "\t%s\n", msg)
+// isQuiet reports whether result is not worth printing: the null left by
+// input that ends in a statement such as let rather than an expression
+func isQuiet(program *ast.Program, result object.Object) bool {
+	if result != object.NULL || len(program.Statements) == 0 {
+		return result == object.NULL
 	}
-}
\ No newline at end of file
+	_, isExpression := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return !isExpression
+}
@@ -1,47 +1,265 @@
 package runner
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/RavenStorm-bit/toy-compiler/ast"
+	"github.com/RavenStorm-bit/toy-compiler/bytecode"
 	"github.com/RavenStorm-bit/toy-compiler/compiler"
+	"github.com/RavenStorm-bit/toy-compiler/evaluator"
 	"github.com/RavenStorm-bit/toy-compiler/lexer"
+	"github.com/RavenStorm-bit/toy-compiler/object"
 	"github.com/RavenStorm-bit/toy-compiler/parser"
 	"github.com/RavenStorm-bit/toy-compiler/vm"
 )
 
-// RunFile executes a source file
-func RunFile(filename string) error {
+// BytecodeExt is the extension of serialized bytecode files
+const BytecodeExt = ".tbc"
+
+// Process exit codes for the outcome of running a program. A program that
+// finishes normally exits with the code derived from its result; see
+// ExitCode.
+const (
+	ExitOK           = 0
+	ExitResultRange  = 62 // the program's integer result was not in 0-61
+	ExitFalse        = 63 // the program's result was false
+	ExitUsage        = 64 // the command line was malformed
+	ExitCompileError = 65 // the program did not parse, compile or load
+	ExitRuntimeError = 70 // the program failed while running
+	ExitIOError      = 74 // a file could not be read or written
+)
+
+// CompileError is returned when a program fails to parse or compile, or
+// bytecode cannot be loaded or fails verification
+type CompileError struct {
+	Err error
+}
+
+func (e *CompileError) Error() string { return e.Err.Error() }
+func (e *CompileError) Unwrap() error { return e.Err }
+
+// RuntimeError is returned when the VM stops with an error
+type RuntimeError struct {
+	Err error
+}
+
+func (e *RuntimeError) Error() string { return "runtime error: " + e.Err.Error() }
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// ExitCode maps the outcome of running a program to a process exit code.
+// Errors map to the Exit* constants. Otherwise an integer result from 0 to
+// 61 is used as the code itself and any other integer exits with
+// ExitResultRange; false exits with ExitFalse; any other result exits with
+// ExitOK. Integer results only use codes below ExitResultRange, so no
+// other outcome reads as one of them.
+func ExitCode(result object.Object, err error) int {
+	if err != nil {
+		var compileErr *CompileError
+		var runtimeErr *RuntimeError
+		switch {
+		case errors.As(err, &compileErr):
+			return ExitCompileError
+		case errors.As(err, &runtimeErr):
+			return ExitRuntimeError
+		default:
+			return ExitIOError
+		}
+	}
+
+	switch result := result.(type) {
+	case *object.Integer:
+		if result.Value < 0 || result.Value >= ExitResultRange {
+			return ExitResultRange
+		}
+		return int(result.Value)
+	case *object.BigInt:
+		// A BigInt only holds values that overflow an Integer
+		return ExitResultRange
+	case *object.Boolean:
+		if !result.Value {
+			return ExitFalse
+		}
+	}
+	return ExitOK
+}
+
+// Backend selects how a program is executed
+type Backend string
+
+const (
+	// BackendVM compiles the program to bytecode and runs it on the VM
+	BackendVM Backend = "vm"
+	// BackendEval walks the AST with the tree-walking evaluator
+	BackendEval Backend = "eval"
+)
+
+// Options configure how Run executes a program. The zero value runs on
+// the VM without tracing.
+type Options struct {
+	Backend Backend
+	Trace   io.Writer // if set, the VM writes each instruction it executes
+}
+
+// RunFile executes a source file, or a bytecode file built by BuildFile,
+// and returns the program's result
+func RunFile(filename string) (object.Object, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("could not read file %s: %w", filename, err)
+		return nil, fmt.Errorf("could not read file %s: %w", filename, err)
 	}
+	return Run(filename, data, Options{})
+}
 
-	return RunSource(string(data))
+// Run executes data, which is either source code or serialized bytecode,
+// and returns the program's result. filename is only used in messages.
+func Run(filename string, data []byte, opts Options) (object.Object, error) {
+	isBytecode := bytes.HasPrefix(data, bytecode.Magic[:])
+
+	switch opts.Backend {
+	case BackendEval:
+		if isBytecode {
+			return nil, &CompileError{fmt.Errorf("%s: the eval backend cannot run bytecode", filename)}
+		}
+		return evalSource(filename, string(data))
+	case BackendVM, "":
+	default:
+		return nil, fmt.Errorf("unknown backend %q", opts.Backend)
+	}
+
+	var bc *bytecode.Bytecode
+	var err error
+	if isBytecode {
+		bc, err = bytecode.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, &CompileError{fmt.Errorf("could not load %s: %w", filename, err)}
+		}
+	} else {
+		bc, err = CompileSource(filename, string(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return runBytecode(filename, bc, opts.Trace)
 }
 
-// RunSource executes source code
-func RunSource(source string) error {
-	l := lexer.New(source)
-	p := parser.New(l)
+// CompileFile parses and compiles a source file
+func CompileFile(filename string) (*bytecode.Bytecode, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file %s: %w", filename, err)
+	}
 
-	// TODO: Parse the full program, not just an expression
-	expression := p.ParseExpression()
-	if len(p.Errors()) != 0 {
-		return fmt.Errorf("parser errors: %v", p.Errors())
+	return CompileSource(filename, string(data))
+}
+
+// BuildFile compiles a source file and writes its bytecode to output. An
+// empty output writes next to the source with the .tbc extension. It
+// returns the name of the file written.
+func BuildFile(filename, output string) (string, error) {
+	bc, err := CompileFile(filename)
+	if err != nil {
+		return "", err
 	}
 
-	comp := compiler.New()
-	err := comp.Compile(expression)
+	if output == "" {
+		output = strings.TrimSuffix(filename, filepath.Ext(filename)) + BytecodeExt
+	}
+	return output, WriteBytecode(bc, output)
+}
+
+// WriteBytecode serializes bc to the file output
+func WriteBytecode(bc *bytecode.Bytecode, output string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	if err := bc.Encode(f); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write %s: %w", output, err)
+	}
+	return f.Close()
+}
+
+// RunSource executes a program and returns its result: the value of a
+// top-level return, or else that of the last top-level statement, which
+// is null unless the statement is an expression
+func RunSource(source string) (object.Object, error) {
+	return runSource("", source)
+}
+
+func runSource(filename, source string) (object.Object, error) {
+	return Run(filename, []byte(source), Options{})
+}
+
+// RunBytecode executes compiled bytecode and returns the program's result
+func RunBytecode(bc *bytecode.Bytecode) (object.Object, error) {
+	return runBytecode("", bc, nil)
+}
+
+// runBytecode runs bc on the VM. Bytecode the VM's verifier rejects never
+// starts running, so it is a *CompileError like a file that cannot be
+// decoded; filename, if known, names it in the message.
+func runBytecode(filename string, bc *bytecode.Bytecode, trace io.Writer) (object.Object, error) {
+	machine := vm.New(bc)
+	machine.SetTrace(trace)
+	if err := machine.Run(); err != nil {
+		var invalid *bytecode.VerifyError
+		if errors.As(err, &invalid) {
+			if filename != "" {
+				err = fmt.Errorf("could not load %s: %w", filename, err)
+			}
+			return nil, &CompileError{err}
+		}
+		return nil, &RuntimeError{err}
+	}
+	return machine.Result(), nil
+}
+
+func evalSource(filename, source string) (object.Object, error) {
+	program, err := ParseSource(filename, source)
 	if err != nil {
-		return fmt.Errorf("compiler error: %w", err)
+		return nil, err
 	}
 
-	machine := vm.New(comp.Bytecode())
-	err = machine.Run()
+	result := evaluator.Eval(program, object.NewEnvironment())
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{errors.New(errObj.Message)}
+	}
+	return result, nil
+}
+
+// ParseSource parses a whole program, reporting syntax errors as a
+// *CompileError
+func ParseSource(filename, source string) (*ast.Program, error) {
+	p := parser.New(lexer.NewFile(filename, source))
+	program := p.ParseProgram()
+	if err := p.Diagnostics().Err(); err != nil {
+		return nil, &CompileError{fmt.Errorf("parser errors:\n%w", err)}
+	}
+	return program, nil
+}
+
+// CompileSource parses and compiles a program. filename is only used in
+// diagnostics.
+func CompileSource(filename, source string) (*bytecode.Bytecode, error) {
+	program, err := ParseSource(filename, source)
 	if err != nil {
-		return fmt.Errorf("vm error: %w", err)
+		return nil, err
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, &CompileError{fmt.Errorf("compiler errors:\n%w", err)}
 	}
 
-	// TODO: Get the result from the VM
-	return nil
+	return comp.Bytecode(), nil
 }
@@ -2,52 +2,343 @@ package stdlib
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/RavenStorm-bit/toy-compiler/object"
 )
 
-// Builtin represents a built-in function
-type Builtin struct {
-	Fn func(args ...interface{}) (interface{}, error)
-}
+// Output is where print writes
+var Output io.Writer = os.Stdout
+
+// Builtins contains all built-in functions. The order is fixed so that
+// compiled code can refer to a builtin by its index.
+var Builtins = []*object.Builtin{
+	{
+		Name: "print",
+		Fn: func(args ...object.Object) object.Object {
+			parts := make([]string, len(args))
+			for i, arg := range args {
+				parts[i] = arg.Inspect()
+			}
+			fmt.Fprintln(Output, strings.Join(parts, " "))
+			return object.NULL
+		},
+	},
+	{
+		Name: "len",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
 
-// Builtins contains all built-in functions
-var Builtins = map[string]*Builtin{
-	"print": {
-		Fn: func(args ...interface{}) (interface{}, error) {
-			for _, arg := range args {
-				fmt.Print(arg)
+			switch arg := args[0].(type) {
+			case *object.String:
+				return &object.Integer{Value: int64(len(arg.Value))}
+			case *object.Array:
+				return &object.Integer{Value: int64(len(arg.Elements))}
+			case *object.Hash:
+				return &object.Integer{Value: int64(len(arg.Pairs))}
+			default:
+				return newError("argument to `len` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	{
+		Name: "type",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return &object.String{Value: string(args[0].Type())}
+		},
+	},
+	{
+		Name: "push",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 2 {
+				return newError("wrong number of arguments. got=%d, want at least 2", len(args))
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+			}
+			arr.Elements = append(arr.Elements, args[1:]...)
+			return arr
+		},
+	},
+	{
+		Name: "pop",
+		Fn: func(args ...object.Object) object.Object {
+			arr, err := arrayArg("pop", args)
+			if err != nil {
+				return err
+			}
+			n := len(arr.Elements)
+			if n == 0 {
+				return newError("pop from empty array")
+			}
+			last := arr.Elements[n-1]
+			arr.Elements = arr.Elements[:n-1]
+			return last
+		},
+	},
+	{
+		Name: "first",
+		Fn: func(args ...object.Object) object.Object {
+			arr, err := arrayArg("first", args)
+			if err != nil {
+				return err
+			}
+			if len(arr.Elements) == 0 {
+				return object.NULL
+			}
+			return arr.Elements[0]
+		},
+	},
+	{
+		Name: "rest",
+		Fn: func(args ...object.Object) object.Object {
+			arr, err := arrayArg("rest", args)
+			if err != nil {
+				return err
+			}
+			if len(arr.Elements) == 0 {
+				return object.NULL
+			}
+			return copyArray(arr.Elements[1:])
+		},
+	},
+	{
+		Name: "slice",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("argument to `slice` must be ARRAY, got %s", args[0].Type())
+			}
+
+			// slice(a, start) runs to the end of the array
+			bounds := []int64{0, int64(len(arr.Elements))}
+			for i, arg := range args[1:] {
+				n, ok := arg.(*object.Integer)
+				if !ok {
+					return newError("slice bounds must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = n.Value
+			}
+
+			start, end := bounds[0], bounds[1]
+			if start < 0 || end < start || end > int64(len(arr.Elements)) {
+				return newError("slice bounds [%d:%d] out of range (length %d)",
+					start, end, len(arr.Elements))
+			}
+			return copyArray(arr.Elements[start:end])
+		},
+	},
+	{
+		Name: "keys",
+		Fn: func(args ...object.Object) object.Object {
+			hash, err := hashArg("keys", args, 1)
+			if err != nil {
+				return err
+			}
+			entries := hash.Entries()
+			keys := make([]object.Object, len(entries))
+			for i, pair := range entries {
+				keys[i] = pair.Key
+			}
+			return &object.Array{Elements: keys}
+		},
+	},
+	{
+		Name: "values",
+		Fn: func(args ...object.Object) object.Object {
+			hash, err := hashArg("values", args, 1)
+			if err != nil {
+				return err
+			}
+			entries := hash.Entries()
+			values := make([]object.Object, len(entries))
+			for i, pair := range entries {
+				values[i] = pair.Value
+			}
+			return &object.Array{Elements: values}
+		},
+	},
+	{
+		Name: "has",
+		Fn: func(args ...object.Object) object.Object {
+			hash, err := hashArg("has", args, 2)
+			if err != nil {
+				return err
 			}
-			fmt.Println()
-			return nil, nil
+			key, ok := args[1].(object.Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			_, found := hash.Get(key)
+			return object.NativeBool(found)
+		},
+	},
+	{
+		Name: "delete",
+		Fn: func(args ...object.Object) object.Object {
+			hash, err := hashArg("delete", args, 2)
+			if err != nil {
+				return err
+			}
+			key, ok := args[1].(object.Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			if value, found := hash.Delete(key); found {
+				return value
+			}
+			return object.NULL
 		},
 	},
-	"len": {
-		Fn: func(args ...interface{}) (interface{}, error) {
+	{
+		Name: "int",
+		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1", len(args))
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
 
 			switch arg := args[0].(type) {
-			case string:
-				return int64(len(arg)), nil
-			case []interface{}:
-				return int64(len(arg)), nil
+			case *object.Integer, *object.BigInt:
+				return arg
+			case *object.Float:
+				// Truncates toward zero
+				return floatToInteger(math.Trunc(arg.Value))
+			case *object.String:
+				value, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+				if !ok {
+					return newError("cannot convert %q to INTEGER", arg.Value)
+				}
+				return object.NewInteger(value)
 			default:
-				return nil, fmt.Errorf("argument to `len` not supported, got %T", args[0])
+				return newError("argument to `int` not supported, got %s", args[0].Type())
 			}
 		},
 	},
-	"type": {
-		Fn: func(args ...interface{}) (interface{}, error) {
+	{
+		Name: "float",
+		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1", len(args))
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInt:
+				value, _ := object.AsFloat(arg)
+				return &object.Float{Value: value}
+			case *object.Float:
+				return arg
+			case *object.String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("cannot convert %q to FLOAT", arg.Value)
+				}
+				return &object.Float{Value: value}
+			default:
+				return newError("argument to `float` not supported, got %s", args[0].Type())
 			}
-			return fmt.Sprintf("%T", args[0]), nil
+		},
+	},
+	{
+		Name: "round",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			value, ok := object.AsFloat(args[0])
+			if !ok {
+				return newError("argument to `round` must be a number, got %s", args[0].Type())
+			}
+
+			// round(x) rounds half away from zero to an integer
+			if len(args) == 1 {
+				if args[0].Type() == object.INTEGER_OBJ {
+					return args[0]
+				}
+				return floatToInteger(math.Round(value))
+			}
+
+			// round(x, digits) keeps that many decimal places
+			digits, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError("round digits must be INTEGER, got %s", args[1].Type())
+			}
+			scale := math.Pow(10, float64(digits.Value))
+			rounded := math.Round(value*scale) / scale
+			if math.IsInf(rounded, 0) || math.IsNaN(rounded) {
+				rounded = value
+			}
+			return &object.Float{Value: rounded}
 		},
 	},
 }
 
 // GetBuiltin returns a built-in function by name
-func GetBuiltin(name string) (*Builtin, bool) {
-	builtin, ok := Builtins[name]
-	return builtin, ok
+func GetBuiltin(name string) (*object.Builtin, bool) {
+	for _, b := range Builtins {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// arrayArg checks that args is a single array
+func arrayArg(name string, args []object.Object) (*object.Array, *object.Error) {
+	if len(args) != 1 {
+		return nil, newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, newError("argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	return arr, nil
+}
+
+// hashArg checks that args has n elements, the first of them a hash
+func hashArg(name string, args []object.Object, n int) (*object.Hash, *object.Error) {
+	if len(args) != n {
+		return nil, newError("wrong number of arguments. got=%d, want=%d", len(args), n)
+	}
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return nil, newError("argument to `%s` must be HASH, got %s", name, args[0].Type())
+	}
+	return hash, nil
+}
+
+// floatToInteger converts a float with no fractional part to an integer
+// of whatever size it needs, failing for NaN and infinities
+func floatToInteger(f float64) object.Object {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return newError("cannot convert %s to INTEGER", (&object.Float{Value: f}).Inspect())
+	}
+	if f >= math.MinInt64 && f < math.MaxInt64 {
+		return &object.Integer{Value: int64(f)}
+	}
+	value, _ := big.NewFloat(f).Int(nil)
+	return object.NewInteger(value)
+}
+
+func copyArray(elements []object.Object) *object.Array {
+	copied := make([]object.Object, len(elements))
+	copy(copied, elements)
+	return &object.Array{Elements: copied}
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
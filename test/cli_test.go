@@ -0,0 +1,164 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/cli"
+	"github.com/RavenStorm-bit/toy-compiler/runner"
+)
+
+func runCLI(t *testing.T, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := cli.Main(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestCLIExitCodes(t *testing.T) {
+	tests := []struct {
+		args     []string
+		stdin    string
+		expected int
+	}{
+		{nil, "", runner.ExitUsage},
+		{[]string{"frobnicate"}, "", runner.ExitUsage},
+		{[]string{"help"}, "", runner.ExitOK},
+		{[]string{"run"}, "40 + 2", 42},
+		{[]string{"run", "-"}, "1 > 2", runner.ExitFalse},
+		{[]string{"run"}, "1", 1},
+		{[]string{"run"}, "63", runner.ExitResultRange},
+		{[]string{"run", "--backend=eval"}, "40 + 2", 42},
+		{[]string{"run", "--backend=jit"}, "1", runner.ExitUsage},
+		{[]string{"run", "--backend=eval", "--trace"}, "1", runner.ExitUsage},
+		{[]string{"run"}, "1 / 0", runner.ExitRuntimeError},
+		{[]string{"run", "--backend=eval"}, "1 / 0", runner.ExitRuntimeError},
+		{[]string{"run"}, "let = 1", runner.ExitCompileError},
+		{[]string{"run", "no-such-file.toy"}, "", runner.ExitIOError},
+		{[]string{"run", "a.toy", "b.toy"}, "", runner.ExitUsage},
+		{[]string{"check"}, "let x = 1; x", runner.ExitOK},
+		{[]string{"check"}, "y", runner.ExitCompileError},
+		{[]string{"fmt", "--json"}, "1", runner.ExitUsage},
+		{[]string{"build"}, "1", runner.ExitUsage},
+	}
+
+	for _, tt := range tests {
+		_, stderr, code := runCLI(t, tt.stdin, tt.args...)
+		if code != tt.expected {
+			t.Errorf("toy %s: wrong exit code. want %d, got %d (stderr: %q)",
+				strings.Join(tt.args, " "), tt.expected, code, stderr)
+		}
+	}
+}
+
+func TestCLIRunJSON(t *testing.T) {
+	stdout, _, code := runCLI(t, `"a" + "b"`, "run", "--json")
+	if code != runner.ExitOK {
+		t.Fatalf("wrong exit code %d", code)
+	}
+
+	var out struct {
+		Result   string `json:"result"`
+		Type     string `json:"type"`
+		ExitCode int    `json:"exit_code"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid JSON %q: %s", stdout, err)
+	}
+	if out.Result != "ab" || out.Type != "STRING" || out.ExitCode != 0 {
+		t.Errorf("wrong result: %+v", out)
+	}
+}
+
+func TestCLICheckJSON(t *testing.T) {
+	stdout, _, code := runCLI(t, "let x = 1;\nprint(y);", "check", "--json")
+	if code != runner.ExitCompileError {
+		t.Fatalf("wrong exit code %d", code)
+	}
+
+	var out struct {
+		File        string `json:"file"`
+		Diagnostics []struct {
+			Severity string `json:"severity"`
+			Message  string `json:"message"`
+			Span     struct {
+				Start struct{ Line, Column int } `json:"start"`
+			} `json:"span"`
+		} `json:"diagnostics"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("invalid JSON %q: %s", stdout, err)
+	}
+	if out.File != "<stdin>" || len(out.Diagnostics) != 1 {
+		t.Fatalf("wrong output: %+v", out)
+	}
+	d := out.Diagnostics[0]
+	if d.Severity != "error" || d.Message != "undefined variable y" ||
+		d.Span.Start.Line != 2 || d.Span.Start.Column != 7 {
+		t.Errorf("wrong diagnostic: %+v", d)
+	}
+}
+
+func TestCLIOutputs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		stdin    string
+		expected []string // substrings of stdout
+	}{
+		{[]string{"run"}, `print("hi")`, []string{"hi\n"}},
+		{[]string{"tokens"}, "let x", []string{`1:1      LET        "let"`, "EOF"}},
+		{[]string{"tokens", "--json"}, "x", []string{`"type": "IDENT"`, `"literal": "x"`}},
+		{[]string{"ast"}, "1 + 2", []string{"InfixExpression 1:1 Operator=\"+\"", "Left: IntegerLiteral 1:1 Value=1"}},
+		{[]string{"ast", "--json"}, "x", []string{`"node": "Identifier"`, `"Value": "x"`}},
+		{[]string{"disasm"}, "1 + 2", []string{"== main ==", "OpAdd"}},
+		{[]string{"fmt"}, "let x=1+2", []string{"let x = 1 + 2;\n"}},
+		{[]string{"repl", "--backend=eval"}, "1 + 1\n", []string{"2\n"}},
+		{[]string{"repl"}, "let x = 2\nx * 3\n", []string{"6\n"}},
+	}
+
+	for _, tt := range tests {
+		stdout, stderr, code := runCLI(t, tt.stdin, tt.args...)
+		if code != runner.ExitOK {
+			t.Errorf("toy %s: exit code %d (stderr: %q)", strings.Join(tt.args, " "), code, stderr)
+			continue
+		}
+		for _, want := range tt.expected {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("toy %s: output %q does not contain %q", strings.Join(tt.args, " "), stdout, want)
+			}
+		}
+	}
+}
+
+func TestCLIFunctionValues(t *testing.T) {
+	source := `
+	let add = fn(a) { fn(b) { a + b } };
+	print(type(add), type(add(1)));
+	print(add(1));
+	print([fn(x) { x * 2 }])`
+	expected := "FUNCTION FUNCTION\nfn(b) {\n(a + b)\n}\n[fn(x) {\n(x * 2)\n}]\n"
+
+	for _, backend := range []string{"vm", "eval"} {
+		stdout, stderr, code := runCLI(t, source, "run", "--backend="+backend)
+		if code != runner.ExitOK {
+			t.Fatalf("%s: exit code %d (stderr: %q)", backend, code, stderr)
+		}
+		if stdout != expected {
+			t.Errorf("%s: wrong output. want=%q, got=%q", backend, expected, stdout)
+		}
+	}
+}
+
+func TestCLITrace(t *testing.T) {
+	_, stderr, code := runCLI(t, "let f = fn() { return 1; }; f()", "run", "--trace")
+	if code != 1 {
+		t.Fatalf("wrong exit code %d", code)
+	}
+	for _, want := range []string{"0000 OpClosure 1 0", "  0000 OpConstant 0", "[1]"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("trace %q does not contain %q", stderr, want)
+		}
+	}
+}
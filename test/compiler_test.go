@@ -0,0 +1,108 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/compiler"
+	"github.com/RavenStorm-bit/toy-compiler/lexer"
+	"github.com/RavenStorm-bit/toy-compiler/parser"
+)
+
+func TestCompilerDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"foo", []string{"1:1: error: undefined variable foo"}},
+		{"let x = 1;\ny = x", []string{"2:1: error: assignment to undeclared variable y"}},
+		{"let f = fn() { a + b }", []string{
+			"1:16: error: undefined variable a",
+			"1:20: error: undefined variable b",
+		}},
+		{"len = 1", []string{"1:1: error: cannot assign to builtin len"}},
+		{"len++", []string{"1:1: error: cannot assign to builtin len"}},
+		{"let x = 1;\ny *= x", []string{"2:1: error: assignment to undeclared variable y"}},
+		{"let f = fn(a) { let g = fn() { a }; g() }; f(1)", nil},
+		{"let f = fn() { f = 1 }", []string{"1:16: error: cannot assign to f inside its own definition"}},
+		{"let f = fn() { fn() { f = 1 } }", []string{"1:23: error: cannot assign to f inside its own definition"}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+
+		diags := comp.Diagnostics()
+		if len(diags) != len(tt.expected) {
+			t.Errorf("wrong number of diagnostics for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.expected), len(diags), err)
+			continue
+		}
+		if len(tt.expected) == 0 && err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.input, err)
+		}
+		for i, d := range diags {
+			if d.Error() != tt.expected[i] {
+				t.Errorf("wrong diagnostic for %q. want=%q, got=%q",
+					tt.input, tt.expected[i], d.Error())
+			}
+		}
+	}
+}
+
+// repeat joins n copies of format, each given its index
+func repeat(format string, n int, sep string) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = fmt.Sprintf(format, i)
+	}
+	return strings.Join(parts, sep)
+}
+
+func TestCompilerOperandLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() { " + repeat("let v%d = true;", 257, " ") + " }",
+			"too many local variables in one function (at most 256)"},
+		{repeat("let g%d = true;", 65537, " "),
+			"too many global variables (at most 65536)"},
+		{repeat("%d", 65537, ";"),
+			"too many constants (at most 65536)"},
+		{"let f = fn() { " + repeat("let a%d = true;", 200, " ") +
+			" fn() { " + repeat("let b%d = true;", 200, " ") +
+			" fn() { [" + repeat("a%d", 200, ", ") + ", " + repeat("b%d", 200, ", ") + "] } } }",
+			"too many captured variables in one function (at most 256)"},
+		{"let f = fn() { 1 }; f(" + strings.Repeat("true, ", 255) + "true)",
+			"too many arguments in one call (at most 255)"},
+		{"[" + strings.Repeat("true, ", 65535) + "true]",
+			"too many elements in one array literal (at most 65535)"},
+		{"if (true) { " + strings.Repeat("true; ", 33000) + "}",
+			"function too long: jumps reach at most 65535 bytes of bytecode"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err == nil {
+			t.Errorf("expected %q, got no error", tt.expected)
+			continue
+		}
+
+		diags := comp.Diagnostics()
+		if len(diags) != 1 || diags[0].Message != tt.expected {
+			t.Errorf("wrong diagnostics. want %q once, got %v", tt.expected, diags)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/evaluator"
+	"github.com/RavenStorm-bit/toy-compiler/lexer"
+	"github.com/RavenStorm-bit/toy-compiler/object"
+	"github.com/RavenStorm-bit/toy-compiler/parser"
+)
+
+func TestEvalPrograms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{`"foo" + "bar"`, "foobar"},
+		{"let x = 5; let y = x * 2; y - 1", "9"},
+		{"1 < 2 == true", "true"},
+		{"-5 + 10 % 4", "-3"},
+		{"1 / 4.0 + 0.5", "0.75"},
+		{"2.0 * 3", "6.0"},
+		{"-0.5 < 0 && 1 == 1.0", "true"},
+		{"round(1.005, 1) + float(int(2.7))", "3.0"},
+		{"0xff", "255"},
+		{"!true == !!false", "true"},
+		{"2 <= 2 && 3 >= 4", "false"},
+		{"false || 1", "true"},
+		{"let n = 0; let f = fn() { n = n + 1; true }; false && f(); true || f(); n", "0"},
+		{"let x = 0; while (x < 10) { x = x + 1; } x", "10"},
+		{"if (1 > 2) { 10 } else { 20 }", "20"},
+		{"if (false) { 10 }", "null"},
+		{"let add = fn(a, b) { return a + b; }; add(2, 3)", "5"},
+		{"let f = fn() { if (true) { return 1; } return 2; }; f()", "1"},
+		{`
+		let makeCounter = fn() {
+			let n = 0;
+			fn() { n = n + 1; n };
+		};
+		let c = makeCounter();
+		c(); c();
+		c()`, "3"},
+		{`
+		let fact = fn(n) {
+			if (n < 2) { return 1; }
+			return n * fact(n - 1);
+		};
+		fact(5)`, "120"},
+		{`len("four")`, "4"},
+		{"return 7; 8", "7"},
+		{"[1, 2 * 2, 3 + 3]", "[1, 4, 6]"},
+		{"let a = [1, 2, 3]; a[0] + a[2]", "4"},
+		{"[[1, 2], [3]][0][1]", "2"},
+		{"let a = [1, 2]; a[1] = 5; a", "[1, 5]"},
+		{"let a = [0]; let f = fn(b) { b[0] = 9; }; f(a); a[0]", "9"},
+		{"let a = []; push(a, 1, 2); push(a, 3)", "[1, 2, 3]"},
+		{"let a = [1, 2]; pop(a) + len(a)", "3"},
+		{"first([7, 8])", "7"},
+		{"first([])", "null"},
+		{"rest([1, 2, 3])", "[2, 3]"},
+		{"slice([1, 2, 3, 4], 1, 3)", "[2, 3]"},
+		{"slice([1, 2, 3], 1)", "[2, 3]"},
+		{"[1, [2]] == [1, [2]]", "true"},
+		{`{"b": 1, "a": 2, 3: true, false: "f"}`, "{b: 1, a: 2, 3: true, false: f}"},
+		{`let m = {"a": 1}; m["a"] + m["a"]`, "2"},
+		{`{1: "x"}[2]`, "null"},
+		{`let m = {}; m["z"] = 1; m["y"] = 2; m["z"] = 3; m`, "{z: 3, y: 2}"},
+		{`let m = {"a": 1, "b": 2, "c": 3}; delete(m, "b"); keys(m)`, "[a, c]"},
+		{`values({"a": 1, "b": [2]})`, "[1, [2]]"},
+		{`has({"a": false}, "a")`, "true"},
+		{`has({}, 1)`, "false"},
+		{`delete({}, 1)`, "null"},
+		{`len({1: 1, 2: 2})`, "2"},
+		{`{"a": 1, "b": 2} == {"b": 2, "a": 1}`, "true"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(t, tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s",
+				tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true", "1:1: type mismatch: INTEGER + BOOLEAN"},
+		{"10 / 0; 1", "1:1: division by zero"},
+		{"1 % 0", "1:1: modulo by zero"},
+		{"2.5 / 0", "1:1: division by zero"},
+		{"-true", "1:1: unsupported type for negation: BOOLEAN"},
+		{`float("x")`, `1:1: cannot convert "x" to FLOAT`},
+		{`let s = "a"; -s`, "1:14: unsupported type for negation: STRING"},
+		{"true && 1 + true", "1:9: type mismatch: INTEGER + BOOLEAN"},
+		{"foo", "1:1: undefined variable foo"},
+		{"x = 1", "1:1: assignment to undeclared variable x"},
+		{`"a" - "b"`, "1:1: unknown operator: STRING - STRING"},
+		{"let f = fn(a) { a }; f(1, 2)", "1:22: wrong number of arguments: want=1, got=2"},
+		{"if (true) { 1 + false; 2 }", "1:13: type mismatch: INTEGER + BOOLEAN"},
+		{"[1, 2][2]", "1:1: array index 2 out of range (length 2)"},
+		{"let i = 0 - 1; [1, 2][i]", "1:16: negative array index -1"},
+		{`[1]["0"]`, "1:1: array index must be INTEGER, got STRING"},
+		{"1[0]", "1:1: index operator not supported: INTEGER"},
+		{"let a = []; a[0] = 1", "1:13: array index 0 out of range (length 0)"},
+		{"pop([])", "1:1: pop from empty array"},
+		{"{[1]: 2}", "1:2: unusable as hash key: ARRAY"},
+		{`{"a": 1}[fn() {}]`, "1:1: unusable as hash key: FUNCTION"},
+		{`let m = {}; m[{}] = 1`, "1:13: unusable as hash key: HASH"},
+		{"keys([])", "1:1: argument to `keys` must be HASH, got ARRAY"},
+		{"slice([1], 0, 2)", "1:1: slice bounds [0:2] out of range (length 1)"},
+		{`for c in "abc" {}`, "1:10: cannot iterate over STRING"},
+		{"let m = {}; m.x += 1", "1:13: type mismatch: NULL + INTEGER"},
+		{"let a = [1]; a.x = 1", "1:14: array index must be INTEGER, got STRING"},
+		{"let x = true; x++", "1:15: type mismatch: BOOLEAN + INTEGER"},
+		{"y += 1", "1:1: assignment to undeclared variable y"},
+		{"for (let i = 0; i < 1; i = i + true) {}", "1:28: type mismatch: INTEGER + BOOLEAN"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", "1:17: stack overflow"},
+		{"let f = fn(n) { let g = fn() { f(n + 1) }; g() }; f(0)", "1:32: stack overflow"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(t, tt.input)
+		errObj, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("no error for %q. got=%T (%s)", tt.input, result, result.Inspect())
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q",
+				tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestEvalCallDepth(t *testing.T) {
+	// Deep recursion below the limit still works, and the depth counts
+	// calls rather than how deeply functions are nested in the source
+	input := fmt.Sprintf(`
+	let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } };
+	count(%d)`, evaluator.MaxCallDepth-1)
+	testExpectedObject(t, input, int64(evaluator.MaxCallDepth-1), testEval(t, input))
+}
+
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	return evaluator.Eval(program, object.NewEnvironment())
+}
+
+func TestEvalLoops(t *testing.T) {
+	for _, tt := range loopTests {
+		testExpectedObject(t, tt.input, tt.expected, testEval(t, tt.input))
+	}
+}
+
+func TestEvalIfExpressions(t *testing.T) {
+	for _, tt := range ifTests {
+		testExpectedObject(t, tt.input, tt.expected, testEval(t, tt.input))
+	}
+}
+
+func TestEvalAssignments(t *testing.T) {
+	for _, tt := range assignmentTests {
+		testExpectedObject(t, tt.input, tt.expected, testEval(t, tt.input))
+	}
+}
+
+func TestEvalBigIntegers(t *testing.T) {
+	for _, tt := range bigIntegerTests {
+		testExpectedObject(t, tt.input, tt.expected, testEval(t, tt.input))
+	}
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/format"
+	"github.com/RavenStorm-bit/toy-compiler/lexer"
+	"github.com/RavenStorm-bit/toy-compiler/parser"
+)
+
+func formatSource(t *testing.T, input string) string {
+	t.Helper()
+	l := lexer.New(input)
+	l.SetEmitComments(true)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	return format.Source(program)
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=5", "let x = 5;\n"},
+		{"x=x+1;", "x = x + 1;\n"},
+		{"(1+2)*3", "(1 + 2) * 3;\n"},
+		{"-a*!b%c", "-a * !b % c;\n"},
+		{"-(a+b)", "-(a + b);\n"},
+		{"- -x", "-(-x);\n"},
+		{"1_000+0xFF*2.50e-3", "1_000 + 0xFF * 2.50e-3;\n"},
+		{"a||b&&c<=d", "a || b && c <= d;\n"},
+		{"(a||b)&&c>=d", "(a || b) && c >= d;\n"},
+		{"1-(2-3)", "1 - (2 - 3);\n"},
+		{"(1-2)-3", "1 - 2 - 3;\n"},
+		{"1+2*3==7", "1 + 2 * 3 == 7;\n"},
+		{`print("hi",1)`, "print(\"hi\", 1);\n"},
+		{"let s=\"a\\\"b\\n\"+`raw\nline`", "let s = \"a\\\"b\\n\" + `raw\nline`;\n"},
+		{"let f=fn(a,b){return a}", "let f = fn(a, b) {\n    return a;\n};\n"},
+		{"let f=fn(){}", "let f = fn() {};\n"},
+		{"if(x){1}else{2}", "if (x) {\n    1;\n} else {\n    2;\n}\n"},
+		{"if(a){1}else if(b){2}else{3}", "if (a) {\n    1;\n} else if (b) {\n    2;\n} else {\n    3;\n}\n"},
+		{"if(a){1}else if(b){2}", "if (a) {\n    1;\n} else if (b) {\n    2;\n}\n"},
+		{"while(x<3){x=x+1}", "while (x < 3) {\n    x = x + 1;\n}\n"},
+		{"for(let i=0;i<3;i=i+1){continue}", "for (let i = 0; i < 3; i = i + 1) {\n    continue;\n}\n"},
+		{"for(;;){break;}", "for (;;) {\n    break;\n}\n"},
+		{"for(;x;f(x)){}", "for (; x; f(x)) {}\n"},
+		{"for k,v in m{print(k)}", "for k, v in m {\n    print(k);\n}\n"},
+		{"fn(){return;}", "fn() {\n    return;\n};\n"},
+		{"[1,[2,3]][0]", "[1, [2, 3]][0];\n"},
+		{"a[i+1]=f(x)[0]", "a[i + 1] = f(x)[0];\n"},
+		{"x+=1;y%=2*z", "x += 1;\ny %= 2 * z;\n"},
+		{"x++;--y;a[i]++;++m.a.b", "x++;\n--y;\na[i]++;\n++m.a.b;\n"},
+		{"m.a.b=f(x).y", "m.a.b = f(x).y;\n"},
+		{"for(let i=0;i<3;i++){}", "for (let i = 0; i < 3; i++) {}\n"},
+		{"(a+b)[0]", "(a + b)[0];\n"},
+		{`let m={"a":1,2:[x]}`, "let m = {\"a\": 1, 2: [x]};\n"},
+		{"{}", "{};\n"},
+		{"let a = 1;\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+	}
+
+	for _, tt := range tests {
+		got := formatSource(t, tt.input)
+		if got != tt.expected {
+			t.Errorf("format(%q): want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFormatIsIdempotent(t *testing.T) {
+	input := `
+let fact = fn(n) {
+	if (n < 2) { return 1; } else { return n * fact(n - 1); }
+};
+let i = 0;
+while (i < (10 - 5) * 2) { i = i + 1; print(fact(i), "!"); }
+(fn(x) { return x; })(3)
+`
+	once := formatSource(t, input)
+	twice := formatSource(t, once)
+	if once != twice {
+		t.Errorf("formatting is not idempotent:\n%s\n---\n%s", once, twice)
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	input := `// header
+
+/// Doubles n.
+let double = fn(n) {
+  // inside
+  return n*2; // trailing
+  /* last */
+};
+let x = double(2); /* after */ // and more
+
+
+let empty = fn() { /* nothing */ };
+if (x) { x } else {
+// only
+}
+// end`
+	expected := `// header
+
+/// Doubles n.
+let double = fn(n) {
+    // inside
+    return n * 2; // trailing
+    /* last */
+};
+let x = double(2); /* after */ // and more
+
+let empty = fn() {
+    /* nothing */
+};
+if (x) {
+    x;
+} else {
+    // only
+}
+// end
+`
+	got := formatSource(t, input)
+	if got != expected {
+		t.Fatalf("wrong output:\n%s\n--- want ---\n%s", got, expected)
+	}
+	if again := formatSource(t, got); again != got {
+		t.Errorf("formatting comments is not idempotent:\n%s", again)
+	}
+}
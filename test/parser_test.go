@@ -1,10 +1,12 @@
 package test
 
 import (
+	"strings"
 	"testing"
 	"github.com/RavenStorm-bit/toy-compiler/lexer"
 	"github.com/RavenStorm-bit/toy-compiler/parser"
 	"github.com/RavenStorm-bit/toy-compiler/ast"
+	"github.com/RavenStorm-bit/toy-compiler/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -139,4 +141,372 @@ func checkParserErrors(t *testing.T, p *parser.Parser) {
 		t.Errorf("parser error: %q", msg)
 	}
 	t.FailNow()
-}
\ No newline at end of file
+}
+func TestArrayParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[]", "[]"},
+		{"[1, 2 * 3, x]", "[1, (2 * 3), x]"},
+		{"a[1 + 1]", "(a[(1 + 1)])"},
+		{"a * [1, 2][0]", "(a * ([1, 2][0]))"},
+		{"f(x)[0]", "(f(x)[0])"},
+		{"a[0][1] = 2", "((a[0])[1]) = 2;"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	p := parser.New(lexer.New("a[0] = 1; f() = 2;"))
+	program := p.ParseProgram()
+	stmt, ok := program.Statements[0].(*ast.AssignmentStatement)
+	if !ok {
+		t.Fatalf("statement 0 is not *ast.AssignmentStatement. got=%T", program.Statements[0])
+	}
+	if _, ok := stmt.Target.(*ast.IndexExpression); !ok {
+		t.Errorf("target is not *ast.IndexExpression. got=%T", stmt.Target)
+	}
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "1:15: error: cannot assign to f()" {
+		t.Errorf("wrong errors: %q", errors)
+	}
+}
+
+func TestHashParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{}", "{}"},
+		{`{"a": 1, 2: x + 1, true: [3]}`, `{"a": 1, 2: (x + 1), true: [3]}`},
+		{`m["k"] = {"n": {}}`, `(m["k"]) = {"n": {}};`},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"a" 1}`, "1:6: error: expected next token to be :, got INT instead"},
+		{`{"a": 1 "b": 2}`, "1:9: error: expected next token to be ,, got STRING instead"},
+	}
+	for _, tt := range errorTests {
+		p := parser.New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: want error %q, got %q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	input := "let add = fn(a, b) {\n  return a + b;\n};\nadd(1, 22)"
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	tests := []struct {
+		node       ast.Node
+		start, end string
+	}{
+		{program, "1:1", "4:11"},
+		{program.Statements[0], "1:1", "3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value, "1:11", "3:2"},
+		{program.Statements[1], "4:1", "4:11"},
+	}
+
+	for i, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.start {
+			t.Errorf("tests[%d] - start wrong. want=%s, got=%s", i, tt.start, got)
+		}
+		if got := tt.node.End().String(); got != tt.end {
+			t.Errorf("tests[%d] - end wrong. want=%s, got=%s", i, tt.end, got)
+		}
+	}
+}
+
+func TestParserRecovery(t *testing.T) {
+	input := `
+let x = 5;
+let = 10;
+let y = (1 + ;
+let f = fn(a) {
+	let = a;
+	return a;
+};
+let z = 15;
+`
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	diags := p.Diagnostics()
+	if len(diags) != 3 {
+		t.Fatalf("expected 3 diagnostics, got %d:\n%s", len(diags), diags.Error())
+	}
+
+	expectedLines := []int{3, 4, 6}
+	for i, d := range diags {
+		if d.Span.Start.Line != expectedLines[i] {
+			t.Errorf("diags[%d] - wrong line. want=%d, got=%d (%s)",
+				i, expectedLines[i], d.Span.Start.Line, d)
+		}
+	}
+
+	if len(diags[0].Expected) != 1 || diags[0].Expected[0] != token.IDENT {
+		t.Errorf("diags[0] - wrong expected set: %v", diags[0].Expected)
+	}
+
+	names := []string{}
+	for _, stmt := range program.Statements {
+		if let, ok := stmt.(*ast.LetStatement); ok {
+			names = append(names, let.Name.Value)
+		}
+	}
+	if strings.Join(names, ",") != "x,f,z" {
+		t.Errorf("wrong statements recovered. got=%v", names)
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	input := `
+/// Adds two numbers.
+///
+/// Both must be integers.
+let add = fn(a, b) { a + b };
+
+/// Detached by the blank line below.
+
+let x = 1;
+/// Detached by the comment below.
+// not a doc comment
+let y = 2;
+let z = 3; /// Trails z, so does not document w.
+let w = 4;
+`
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := []string{
+		"Adds two numbers.\n\nBoth must be integers.",
+		"",
+		"",
+		"",
+		"",
+	}
+	if len(program.Statements) != len(expected) {
+		t.Fatalf("expected %d statements, got %d", len(expected), len(program.Statements))
+	}
+	for i, want := range expected {
+		stmt := program.Statements[i].(*ast.LetStatement)
+		if stmt.Doc != want {
+			t.Errorf("%s: expected doc %q, got %q", stmt.Name.Value, want, stmt.Doc)
+		}
+	}
+
+	if len(program.Comments) != 6 {
+		t.Errorf("expected 6 comments, got %d", len(program.Comments))
+	}
+}
+
+func TestStringLiteralErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let s = "abc`, "1:9: error: unterminated string (hint: use \\n for a line break, or a `raw string` to span lines)"},
+		{"let s = \"ab\nc\";", "1:9: error: unterminated string (hint: use \\n for a line break, or a `raw string` to span lines)"},
+		{"let s = `abc", "1:9: error: unterminated string"},
+		{`let s = "ab\qc";`, `1:12: error: unknown escape sequence \q`},
+		{`print("x", "\u{zz}")`, `1:13: error: invalid hex digits in \u{zz}`},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: want error %q, got %q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAssignmentParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1", "x = 1;"},
+		{"x += 1 + 2", "x += (1 + 2);"},
+		{"x -= 1; x *= 2; x /= 3; x %= 4", "x -= 1;x *= 2;x /= 3;x %= 4;"},
+		{"x++; y--", "x++;y--;"},
+		{"++x; --a[0]", "++x;--(a[0]);"},
+		{"a[i] += 1", "(a[i]) += 1;"},
+		{"m.a.b = 2", "((m.a).b) = 2;"},
+		{"m.f(1).x", "((m.f)(1).x)"},
+		{"- -x", "(-(-x))"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 += 2", "1:3: error: cannot assign to 1"},
+		{"f()++", "1:4: error: cannot assign to f()"},
+		{"++1", "1:1: error: cannot assign to 1"},
+		{"f(x++)", "1:4: error: expected next token to be ), got ++ instead" +
+			" (hint: ++ is a statement and cannot be used inside an expression)"},
+		{"let y = --x;", "1:9: error: expected an expression, got --" +
+			" (hint: -- is a statement and cannot be used inside an expression)"},
+		{"a.1 = 2", "1:3: error: expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: want error %q, got %q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestLoopParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for (let i = 0; i < n; i = i + 1) { f(i); }", "for (let i = 0; (i < n); i = (i + 1)) f(i)"},
+		{"for (;;) { break; }", "for (; ; ) break;"},
+		{"for (i = 0; ; a[i] = 1) { continue }", "for (i = 0; ; (a[i]) = 1) continue;"},
+		{"for x in xs { x }", "for x in xs x"},
+		{"for k, v in {1: 2} {}", "for k, v in {1: 2} "},
+		{"if (a) { 1 } else if (b) { 2 } else { 3 }", "ifa 1else ifb 2else 3"},
+		{"while (true) { let f = fn() { return 1; }; break; }", "whiletrue let f = fn() return 1;;break;"},
+		// A loop may end with a semicolon like any other statement
+		{"for x in [1] {}; 1", "for x in [1] 1"},
+		{"for (let i = 0; i < 1; i++) {}; 1", "for (let i = 0; (i < 1); i++) 1"},
+		{"while (false) {}; 1", "whilefalse 1"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: want %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: error: break outside a loop"},
+		{"if (true) { continue; }", "1:13: error: continue outside a loop"},
+		{"while (true) { fn() { break; }; }", "1:23: error: break outside a loop"},
+		{"for (x in xs) {}", "1:8: error: expected next token to be ;, got IN instead" +
+			" (hint: write a for-in loop without parentheses: for x in xs { ... })"},
+		{"for (let i = 0; i < 3) {}", "1:22: error: expected next token to be ;, got ) instead"},
+		{"for x, y, z in xs {}", "1:9: error: expected next token to be IN, got , instead"},
+		{"for 1 in xs {}", "1:5: error: expected next token to be IDENT, got INT instead"},
+		{"for x in xs { print(if (x) { continue } else { x }) }", "1:30: error: continue inside an expression" +
+			" (hint: only an if that is a statement on its own can leave the loop)"},
+		{"while (true) { let x = if (true) { break } else { 1 }; }", "1:36: error: break inside an expression" +
+			" (hint: only an if that is a statement on its own can leave the loop)"},
+		{"while (true) { if (if (true) { break } else { true }) {} }", "1:32: error: break inside an expression" +
+			" (hint: only an if that is a statement on its own can leave the loop)"},
+		{"while (true) { if (true) { break } else { 1 } + 1 }", "1:28: error: break inside an expression" +
+			" (hint: only an if that is a statement on its own can leave the loop)"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: want error %q, got %q", tt.input, tt.expected, errors)
+		}
+	}
+
+	// A break nested in several expressions is reported once
+	p := parser.New(lexer.New("while (true) { f(if (true) { g(if (true) { break } else { 1 }) } else { 2 }) }"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 {
+		t.Errorf("want 1 error, got %q", p.Errors())
+	}
+
+	// An if standing as a statement may leave the loop from any branch
+	valid := []string{
+		"while (true) { if (true) { break } }",
+		"while (true) { if (false) { 1 } else if (true) { if (true) { continue } } else { break } }",
+		"for x in xs { let f = fn() { while (true) { break } }; if (x) { break; } }",
+		"while (true) { print(if (true) { while (true) { break } 1 } else { 2 }) }",
+	}
+	for _, input := range valid {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("%q: unexpected errors %q", input, p.Errors())
+		}
+	}
+}
+
+func TestNumberLiteralErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0755", "1:1: error: invalid integer literal 0755 (hint: write octal numbers as 0o755)"},
+		{"0x", "1:1: error: invalid integer literal 0x"},
+		{"0b102", "1:1: error: invalid integer literal 0b102"},
+		{"1__0", "1:1: error: invalid integer literal 1__0"},
+		{"0x_", "1:1: error: invalid integer literal 0x_"},
+		{"1e400", "1:1: error: float literal 1e400 out of range"},
+		{"1_.5", "1:1: error: invalid float literal 1_.5"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("%q: want error %q, got %q", tt.input, tt.expected, errors)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/repl"
+	"github.com/RavenStorm-bit/toy-compiler/runner"
+)
+
+// runREPL feeds input to a session and returns its output with the
+// prompts removed, one entry per line
+func runREPL(t *testing.T, backend runner.Backend, input string) []string {
+	t.Helper()
+	var out bytes.Buffer
+	repl.Start(strings.NewReader(input), &out, backend)
+
+	var lines []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		for strings.HasPrefix(line, repl.PROMPT) || strings.HasPrefix(line, repl.CONTINUE_PROMPT) {
+			line = line[len(repl.PROMPT):]
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestREPLSession(t *testing.T) {
+	input := `let x = 5
+let add = fn(a, b) {
+  a + b
+}
+add(x, 2)
+x += 1; x
+let m = {"a": [1, 2]}
+m.a
+let y = 1 / 0
+y
+z
+let z = 3
+z
+:reset
+x
+`
+	// The VM compiles each input before running it, so undefined names
+	// are compile errors there; a let that failed leaves its variable null
+	expected := map[runner.Backend][]string{
+		runner.BackendVM: {
+			"7", "6", "[1, 2]",
+			"runtime error: 1:9: division by zero",
+			"null",
+			"compiler errors:", "1:1: error: undefined variable z",
+			"3",
+			"compiler errors:", "1:1: error: undefined variable x",
+		},
+		runner.BackendEval: {
+			"7", "6", "[1, 2]",
+			"runtime error: 1:9: division by zero",
+			"runtime error: 1:1: undefined variable y",
+			"runtime error: 1:1: undefined variable z",
+			"3",
+			"runtime error: 1:1: undefined variable x",
+		},
+	}
+
+	for backend, want := range expected {
+		got := runREPL(t, backend, input)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: wrong output.\nwant=%q\ngot= %q", backend, want, got)
+		}
+	}
+}
+
+func TestREPLCommands(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "lib.toy")
+	if err := ioutil.WriteFile(file, []byte("let double = fn(x) { x * 2 };"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected []string // substrings of the output
+	}{
+		{":tokens let x", []string{`1:1      LET        "let"`, `1:5      IDENT      "x"`}},
+		{":ast 1 + 2", []string{`InfixExpression 1:1 Operator="+"`, "Left: IntegerLiteral 1:1 Value=1"}},
+		{"let x = 1\n:bytecode x + 2", []string{"OpGetGlobal 0", "OpAdd"}},
+		{":bytecode let y = 1\ny", []string{"OpSetGlobal 0", "undefined variable y"}},
+		{":load " + file + "\ndouble(21)", []string{"42"}},
+		{":load " + filepath.Join(dir, "missing.toy"), []string{"could not read file"}},
+		{":backend", []string{"vm"}},
+		{":backend eval\n:bytecode 1", []string{"switched to the eval backend", ":bytecode requires the vm backend"}},
+		{":backend jit", []string{`unknown backend "jit"`}},
+		{":tokens", []string{"usage: :tokens <code>"}},
+		{":frobnicate", []string{"unknown command :frobnicate"}},
+		{":help", []string{":load <file>", ":reset"}},
+		{":quit\n1", []string{"Goodbye!"}},
+		{"/* a\nb */ \"a\" + `c\nd`", []string{"ac\nd"}},
+	}
+
+	for _, tt := range tests {
+		out := strings.Join(runREPL(t, runner.BackendVM, tt.input+"\n"), "\n")
+		for _, want := range tt.expected {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q: output %q does not contain %q", tt.input, out, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/bytecode"
+	"github.com/RavenStorm-bit/toy-compiler/object"
+	"github.com/RavenStorm-bit/toy-compiler/runner"
+)
+
+func TestRunSourceResult(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 + 2", int64(3)},
+		{"let x = 5; x * 2; x + 1", int64(6)},
+		{`return "early"; 1`, "early"},
+		{"let f = fn() { return 7; }; return f();", int64(7)},
+		{"let x = 5;", nil},
+		{"return;", nil},
+		{"", nil},
+		// The result is that of the last top-level statement, which is
+		// null unless it is an expression
+		{"5; let x = 3;", nil},
+		{"7; for x in [1] { x }", nil},
+		{"7; while (false) { 1 }", nil},
+		{"let a = [1]; 9; a[0] = 2", nil},
+		{"let x = 1; 9; x = 2", nil},
+		{"let n = 0; for x in [1, 2] { n += x }\nn + 10", int64(13)},
+		{"8; if (false) { 1 }", nil},
+		// A function body can use a global that a later let defines
+		{"let a = fn() { b() }; let b = fn() { 1 }; a()", int64(1)},
+		{"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };\n" +
+			"let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };\n" +
+			"even(10)", true},
+		{"let f = fn() { c = 3 }; let c = 1; f(); c", int64(3)},
+	}
+
+	for _, backend := range []runner.Backend{runner.BackendVM, runner.BackendEval} {
+		for _, tt := range tests {
+			result, err := runner.Run("", []byte(tt.input), runner.Options{Backend: backend})
+			if err != nil {
+				t.Fatalf("%s: %q: unexpected error: %s", backend, tt.input, err)
+			}
+			testExpectedObject(t, string(backend)+": "+tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestRunSourceErrors(t *testing.T) {
+	_, err := runner.RunSource("let = 1;")
+	var compileErr *runner.CompileError
+	if !errors.As(err, &compileErr) {
+		t.Errorf("parse failure: expected *runner.CompileError, got %T (%v)", err, err)
+	}
+
+	_, err = runner.RunSource("undefinedName")
+	if !errors.As(err, &compileErr) {
+		t.Errorf("compile failure: expected *runner.CompileError, got %T (%v)", err, err)
+	}
+
+	_, err = runner.RunSource("1 / 0")
+	var runtimeErr *runner.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Errorf("runtime failure: expected *runner.RuntimeError, got %T (%v)", err, err)
+	}
+}
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the position both backends report
+	}{
+		{"let x = 1 / 0", "prog.toy:1:9: "},
+		{"let f = fn(a) {\n  a / 0\n};\nf(1)", "prog.toy:2:3: "},
+		{"let f = fn(a) { a }; f(1, 2)", "prog.toy:1:22: "},
+		{"let a = [1, 2];\na[5]", "prog.toy:2:1: "},
+		{"for x in 5 {}", "prog.toy:1:10: "},
+		{"let m = {}; m.x += 1", "prog.toy:1:13: "},
+		{"let a = 1; a[0] = 2", "prog.toy:1:12: "},
+		{"let n = 0;\nwhile (true) {\n  n = n + [1]\n}", "prog.toy:3:7: "},
+	}
+
+	for _, backend := range []runner.Backend{runner.BackendVM, runner.BackendEval} {
+		for _, tt := range tests {
+			_, err := runner.Run("prog.toy", []byte(tt.input), runner.Options{Backend: backend})
+			var runtimeErr *runner.RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Errorf("%s: %q: expected *runner.RuntimeError, got %T (%v)", backend, tt.input, err, err)
+				continue
+			}
+			if !strings.HasPrefix(runtimeErr.Err.Error(), tt.expected) {
+				t.Errorf("%s: %q: want position %q, got %q", backend, tt.input, tt.expected, runtimeErr.Err)
+			}
+		}
+	}
+
+	// The positions are kept when the bytecode is saved and loaded
+	bc, err := runner.CompileSource("prog.toy", "let f = fn(a) {\n  a / 0\n};\nf(1)")
+	if err != nil {
+		t.Fatalf("compile failed: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := bc.Encode(&buf); err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+	_, err = runner.Run("prog.tbc", buf.Bytes(), runner.Options{})
+	if err == nil || err.Error() != "runtime error: prog.toy:2:3: division by zero" {
+		t.Errorf("wrong error from loaded bytecode: %v", err)
+	}
+}
+
+func TestRuntimeErrorMessages(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the error both backends report
+	}{
+		{"slice([1, 2], 5, 1)", "runtime error: 1:1: slice bounds [5:1] out of range (length 2)"},
+		{`1 + "a"`, "runtime error: 1:1: type mismatch: INTEGER + STRING"},
+		{`"a" < "b"`, "runtime error: 1:1: unknown operator: STRING < STRING"},
+		{"[1] + [2]", "runtime error: 1:1: unknown operator: ARRAY + ARRAY"},
+		{"1(2)", "runtime error: 1:1: not a function: INTEGER"},
+		{"pop([])", "runtime error: 1:1: pop from empty array"},
+	}
+
+	for _, backend := range []runner.Backend{runner.BackendVM, runner.BackendEval} {
+		for _, tt := range tests {
+			_, err := runner.Run("", []byte(tt.input), runner.Options{Backend: backend})
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("%s: %q: want error %q, got %v", backend, tt.input, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestRunInvalidBytecode(t *testing.T) {
+	// A local in the main program is rejected by the verifier
+	bc := &bytecode.Bytecode{Instructions: bytecode.Make(bytecode.OpGetLocal, 0)}
+
+	_, err := runner.RunBytecode(bc)
+	var compileErr *runner.CompileError
+	if !errors.As(err, &compileErr) {
+		t.Errorf("RunBytecode: expected *runner.CompileError, got %T (%v)", err, err)
+	}
+
+	var buf bytes.Buffer
+	if err := bc.Encode(&buf); err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+	_, err = runner.Run("bad.tbc", buf.Bytes(), runner.Options{})
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("Run: expected *runner.CompileError, got %T (%v)", err, err)
+	}
+	if !strings.HasPrefix(err.Error(), "could not load bad.tbc: invalid bytecode") {
+		t.Errorf("wrong message %q", err)
+	}
+	if code := runner.ExitCode(nil, err); code != runner.ExitCompileError {
+		t.Errorf("wrong exit code. want %d, got %d", runner.ExitCompileError, code)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		result   object.Object
+		err      error
+		expected int
+	}{
+		{object.NULL, nil, runner.ExitOK},
+		{&object.Integer{Value: 0}, nil, 0},
+		{&object.Integer{Value: 1}, nil, 1},
+		{&object.Integer{Value: 42}, nil, 42},
+		{&object.Integer{Value: 61}, nil, 61},
+		{&object.Integer{Value: 62}, nil, runner.ExitResultRange},
+		{&object.Integer{Value: 63}, nil, runner.ExitResultRange},
+		{&object.Integer{Value: 64}, nil, runner.ExitResultRange},
+		{&object.Integer{Value: 65}, nil, runner.ExitResultRange},
+		{&object.Integer{Value: 70}, nil, runner.ExitResultRange},
+		{&object.Integer{Value: 256}, nil, runner.ExitResultRange},
+		{&object.Integer{Value: -1}, nil, runner.ExitResultRange},
+		{&object.BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 70)}, nil, runner.ExitResultRange},
+		{&object.BigInt{Value: new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 70))}, nil, runner.ExitResultRange},
+		{object.TRUE, nil, runner.ExitOK},
+		{object.FALSE, nil, runner.ExitFalse},
+		{&object.String{Value: "done"}, nil, runner.ExitOK},
+		{nil, &runner.CompileError{Err: errors.New("bad")}, runner.ExitCompileError},
+		{nil, &runner.RuntimeError{Err: errors.New("bad")}, runner.ExitRuntimeError},
+		{nil, errors.New("could not read file"), runner.ExitIOError},
+	}
+
+	for i, tt := range tests {
+		if got := runner.ExitCode(tt.result, tt.err); got != tt.expected {
+			t.Errorf("tests[%d]: wrong exit code. want %d, got %d", i, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,681 @@
+package test
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/RavenStorm-bit/toy-compiler/bytecode"
+	"github.com/RavenStorm-bit/toy-compiler/compiler"
+	"github.com/RavenStorm-bit/toy-compiler/lexer"
+	"github.com/RavenStorm-bit/toy-compiler/object"
+	"github.com/RavenStorm-bit/toy-compiler/parser"
+	"github.com/RavenStorm-bit/toy-compiler/vm"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestVMPrograms(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 + 2 * 3", int64(7)},
+		{"let x = 5; let y = x * 2; y - 1", int64(9)},
+		{`"hello"`, "hello"},
+		{"1 < 2", true},
+		{"2 != 2", false},
+		{"let x = 0; while (x < 10) { x = x + 1; } x", int64(10)},
+		{"let x = 1; if (x > 0) { x = 10; } else { x = 20; } x", int64(10)},
+		{"let x = 1; if (x > 5) { x = 10; } x", int64(1)},
+		{"let add = fn(a, b) { return a + b; }; add(2, 3)", int64(5)},
+		{"let f = fn() { let a = 4; let b = a * a; return b; }; f()", int64(16)},
+		{"let noop = fn() { }; noop()", nil},
+		{`len("four") + len("")`, 4},
+		{`type(1)`, "INTEGER"},
+		{`
+		let fact = fn(n) {
+			if (n < 2) { return 1; }
+			return n * fact(n - 1);
+		};
+		fact(5)`, int64(120)},
+		{"let x = 1; let x = x + 1; x", int64(2)},
+		{"let f = fn() { let x = 1; let x = x * 3; x }; f()", int64(3)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMLetCannotReadItself(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = x + 1", "1:9: error: undefined variable x"},
+		{"let x = x; x", "1:9: error: undefined variable x"},
+		{"fn() { let y = y; y }()", "1:16: error: undefined variable y"},
+		{"let a = b; let b = 1", "1:9: error: undefined variable b"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		err := compiler.New().Compile(program)
+		if err == nil {
+			t.Errorf("%q: expected a compile error", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%q: wrong error. want %q, got %q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestVMClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let newAdder = fn(a) { return fn(b) { return a + b; }; };
+		let addTwo = newAdder(2);
+		addTwo(3)`, 5},
+		{`
+		let outer = fn(a) {
+			let middle = fn(b) {
+				let inner = fn(c) { return a + b + c; };
+				return inner;
+			};
+			return middle;
+		};
+		outer(1)(10)(100)`, 111},
+		{`
+		let makeCounter = fn() {
+			let n = 0;
+			return fn() { n = n + 1; return n; };
+		};
+		let c = makeCounter();
+		c(); c();
+		c()`, 3},
+		{`
+		let wrapper = fn() {
+			let countDown = fn(x) {
+				if (x == 0) { return 0; }
+				return countDown(x - 1);
+			};
+			return countDown(5);
+		};
+		wrapper()`, 0},
+		{`
+		let fib = fn(n) {
+			if (n < 2) { return n; }
+			return fib(n - 1) + fib(n - 2);
+		};
+		fib(15)`, 610},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMArrays(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []interface{}{}},
+		{"[1, 2 * 2, 3 + 3]", []interface{}{1, 4, 6}},
+		{"let a = [1, 2, 3]; a[0] + a[2]", int64(4)},
+		{"[[1, 2], [3]][0][1]", int64(2)},
+		{"let a = [1, 2]; a[1] = 5; a", []interface{}{1, 5}},
+		{"let a = [0]; let f = fn(b) { b[0] = 9; }; f(a); a[0]", int64(9)},
+		{"let f = fn() { let a = [1]; a[0] = a[0] + 1; return a[0]; }; f()", int64(2)},
+		{"let a = []; push(a, 1, 2); push(a, 3)", []interface{}{1, 2, 3}},
+		{"let a = [1, 2]; pop(a) + len(a)", int64(3)},
+		{"first([7, 8])", int64(7)},
+		{"first([])", nil},
+		{"rest([1, 2, 3])", []interface{}{2, 3}},
+		{"slice([1, 2, 3, 4], 1, 3)", []interface{}{2, 3}},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMHashes(t *testing.T) {
+	ordered := func(pairs ...object.Object) *object.Hash {
+		h := object.NewHash()
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i].(object.Hashable), pairs[i+1])
+		}
+		return h
+	}
+	str := func(s string) object.Object { return &object.String{Value: s} }
+	integer := func(i int64) object.Object { return &object.Integer{Value: i} }
+
+	tests := []vmTestCase{
+		{"{}", object.NewHash()},
+		{`{"a": 1 + 1, 2: "b"}`, ordered(str("a"), integer(2), integer(2), str("b"))},
+		{`let m = {"a": 1}; m["a"] + m["a"]`, int64(2)},
+		{`{1: "x"}[2]`, nil},
+		{`let m = {}; m["z"] = 1; m["y"] = 2; m["z"] = 3; keys(m)`, []interface{}{"z", "y"}},
+		{`let m = {"a": 1, "b": 2}; delete(m, "a")`, int64(1)},
+		{`values({"b": 1, "a": 2})`, []interface{}{1, 2}},
+		{`has({true: 1}, true)`, true},
+		{`let f = fn(m) { m["n"] = 1; }; let m = {}; f(m); m["n"]`, int64(1)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func runVMTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+
+		machine := vm.New(comp.Bytecode())
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		got := machine.LastPoppedStackElem()
+		testExpectedObject(t, tt.input, tt.expected, got)
+	}
+}
+
+func testExpectedObject(t *testing.T, input string, expected interface{}, got object.Object) {
+	t.Helper()
+
+	want := expectedObject(t, expected)
+	if got == nil || got.Type() != want.Type() || !object.Equal(want, got) {
+		gotDesc := "<nil>"
+		if got != nil {
+			gotDesc = fmt.Sprintf("%s (%s)", got.Inspect(), got.Type())
+		}
+		t.Errorf("wrong result for %q. want=%s (%s), got=%s",
+			input, want.Inspect(), want.Type(), gotDesc)
+	}
+}
+
+// expectedObject converts a Go value from a test table to the object it
+// describes; []interface{} describes an array
+func expectedObject(t *testing.T, expected interface{}) object.Object {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int64:
+		return &object.Integer{Value: expected}
+	case int:
+		return &object.Integer{Value: int64(expected)}
+	case float64:
+		return &object.Float{Value: expected}
+	case string:
+		return &object.String{Value: expected}
+	case bool:
+		return object.NativeBool(expected)
+	case nil:
+		return object.NULL
+	case []interface{}:
+		elements := make([]object.Object, len(expected))
+		for i, e := range expected {
+			elements[i] = expectedObject(t, e)
+		}
+		return &object.Array{Elements: elements}
+	case object.Object:
+		return expected
+	default:
+		t.Fatalf("unsupported expected value %T", expected)
+		return nil
+	}
+}
+
+func TestVMRefusesMalformedBytecode(t *testing.T) {
+	bc := &bytecode.Bytecode{
+		Instructions: bytecode.Make(bytecode.OpConstant, 7),
+	}
+
+	machine := vm.New(bc)
+	err := machine.Run()
+	if err == nil {
+		t.Fatalf("malformed bytecode ran without error")
+	}
+	if !strings.Contains(err.Error(), "constant index 7 out of range") {
+		t.Errorf("wrong error: %s", err)
+	}
+}
+
+// TestVMCraftedBytecode runs hand-written bytecode that passes the
+// verifier but reads slots nothing has set. It must fail with an error or
+// run with null, never panic.
+func TestVMCraftedBytecode(t *testing.T) {
+	fn := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concatInstructions(ins...), NumLocals: numLocals}
+	}
+	get0 := bytecode.Make(bytecode.OpGetGlobal, 0)
+	get1 := bytecode.Make(bytecode.OpGetGlobal, 1)
+	pop := bytecode.Make(bytecode.OpPop)
+
+	tests := []struct {
+		name      string
+		bc        *bytecode.Bytecode
+		errSubstr string // empty if the program should run
+	}{
+		{"call unset global", &bytecode.Bytecode{Instructions: concatInstructions(
+			get0, bytecode.Make(bytecode.OpCall, 0), pop)}, "not a function: NULL"},
+		{"negate unset global", &bytecode.Bytecode{Instructions: concatInstructions(
+			get0, bytecode.Make(bytecode.OpMinus), pop)}, "unsupported type for negation: NULL"},
+		{"hash of unset globals", &bytecode.Bytecode{Instructions: concatInstructions(
+			get0, get1, bytecode.Make(bytecode.OpHash, 2), pop)}, "unusable as hash key: NULL"},
+		{"add unset globals", &bytecode.Bytecode{Instructions: concatInstructions(
+			get0, get1, bytecode.Make(bytecode.OpAdd), pop)}, "unknown operator: NULL + NULL"},
+		{"compare unset globals", &bytecode.Bytecode{Instructions: concatInstructions(
+			get0, get1, bytecode.Make(bytecode.OpEqual), pop)}, ""},
+		{"index unset global", &bytecode.Bytecode{Instructions: concatInstructions(
+			get0, get1, bytecode.Make(bytecode.OpIndex), pop)}, "index operator not supported: NULL"},
+		{"unset local", &bytecode.Bytecode{
+			Instructions: concatInstructions(
+				bytecode.Make(bytecode.OpClosure, 0, 0), bytecode.Make(bytecode.OpCall, 0), pop),
+			Constants: []object.Object{fn(1,
+				bytecode.Make(bytecode.OpGetLocal, 0), bytecode.Make(bytecode.OpMinus),
+				bytecode.Make(bytecode.OpReturnValue))},
+		}, "unsupported type for negation: NULL"},
+		{"nil constant", &bytecode.Bytecode{
+			Instructions: concatInstructions(bytecode.Make(bytecode.OpConstant, 0), pop),
+			Constants:    []object.Object{nil},
+		}, "constant 0 is nil"},
+	}
+
+	for _, tt := range tests {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return vm.New(tt.bc).Run()
+		}()
+
+		switch {
+		case err != nil && strings.HasPrefix(err.Error(), "panic: "):
+			t.Errorf("%s: %s", tt.name, err)
+		case tt.errSubstr == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+		case tt.errSubstr != "" && (err == nil || !strings.Contains(err.Error(), tt.errSubstr)):
+			t.Errorf("%s: wrong error. want %q, got %v", tt.name, tt.errSubstr, err)
+		}
+	}
+}
+
+func TestVMTruthinessAndStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`"foo" + "bar"`, "foobar"},
+		{`let s = "a"; s = s + "b"; s + "c"`, "abc"},
+		{`"a\"b\\c\n\t\u{e9}\u{1F600}"`, "a\"b\\c\n\té😀"},
+		{"`raw \\n\n\"x\"`", "raw \\n\n\"x\""},
+		{"let x = 0; if (1) { x = 1; } x", int64(1)},
+		{`let x = 0; if ("") { x = 1; } x`, int64(1)},
+		{"let x = 0; let noop = fn() { }; if (noop()) { x = 1; } x", int64(0)},
+		{"let x = 0; while (false) { x = 1; } x", int64(0)},
+		{"let n = 3; let x = 0; while (n) { n = n - 1; if (n == 0) { n = false; } x = x + 1; } x", int64(3)},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMUnaryOperators(t *testing.T) {
+	tests := []struct {
+		operand  object.Object
+		op       bytecode.Opcode
+		expected interface{}
+	}{
+		{&object.Integer{Value: 5}, bytecode.OpMinus, int64(-5)},
+		{&object.Integer{Value: -7}, bytecode.OpMinus, int64(7)},
+		{&object.Integer{Value: 0}, bytecode.OpBang, false},
+		{object.TRUE, bytecode.OpBang, false},
+		{object.FALSE, bytecode.OpBang, true},
+		{object.NULL, bytecode.OpBang, true},
+	}
+
+	for _, tt := range tests {
+		bc := &bytecode.Bytecode{
+			Instructions: concatInstructions(
+				bytecode.Make(bytecode.OpConstant, 0),
+				bytecode.Make(tt.op),
+				bytecode.Make(bytecode.OpPop),
+			),
+			Constants: []object.Object{tt.operand},
+		}
+
+		machine := vm.New(bc)
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.operand.Inspect(), tt.expected, machine.LastPoppedStackElem())
+	}
+}
+
+func TestVMOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"-5 + 10", int64(5)},
+		{"- -3", int64(3)},
+		{"!true", false},
+		{"!!1", true},
+		{"let noop = fn() { }; !noop()", true},
+		{"7 % 3", int64(1)},
+		{"-7 % 3", int64(-1)},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 3", false},
+		{"3 >= 3", true},
+		{"true && 1", true},
+		{"1 && false", false},
+		{"false || 0", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3 || false", true},
+		{`
+		let calls = 0;
+		let touch = fn() { calls = calls + 1; return true; };
+		false && touch();
+		true || touch();
+		true && touch();
+		false || touch();
+		calls`, int64(2)},
+		{"let f = fn(x) { return x > 0 && x < 10; }; f(5)", true},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestVMFloats(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.5", 3.5},
+		{"1e3", 1000.0},
+		{"2.5e-1 * 4", 1.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"7 / 2", int64(3)},
+		{"7 / 2.0", 3.5},
+		{"-1.5", -1.5},
+		{"5.5 % 2", 1.5},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+		{"2 < 2.5", true},
+		{"2.5 >= 3", false},
+		{"0x1F + 0o17 + 0b11", int64(49)},
+		{"1_000 * 1_000", int64(1000000)},
+		{"[1.5][0]", 1.5},
+		{"int(2.9) + int(-2.9)", int64(0)},
+		{`int(" 42 ")`, int64(42)},
+		{"float(2)", 2.0},
+		{`float("0.25")`, 0.25},
+		{"round(2.5)", int64(3)},
+		{"round(-2.5)", int64(-3)},
+		{"round(7)", int64(7)},
+		{"round(3.14159, 2)", 3.14},
+		{`type(1.0)`, "FLOAT"},
+	}
+
+	runVMTests(t, tests)
+}
+
+// bigIntegerTests are shared with the evaluator, which must agree
+var bigIntegerTests = []vmTestCase{
+	{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+	{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+	{"4294967296 * 4294967296", bigInt("18446744073709551616")},
+	{"let m = 0 - 9223372036854775807 - 1; -m", bigInt("9223372036854775808")},
+	{"let m = 0 - 9223372036854775807 - 1; m / -1", bigInt("9223372036854775808")},
+	{"99999999999999999999999 + 1", bigInt("100000000000000000000000")},
+	{"0xffffffffffffffffff", bigInt("4722366482869645213695")},
+	// Results that fit are demoted
+	{"9223372036854775808 - 1", int64(9223372036854775807)},
+	{"100000000000000000000 / 10000000000", int64(10000000000)},
+	{"-100000000000000000007 % 10", int64(-7)},
+	{"-100000000000000000007 / 10", bigInt("-10000000000000000000")},
+	{"100000000000000000000 > 9223372036854775807", true},
+	{"100000000000000000000 == 100000000000000000000", true},
+	{"100000000000000000000 == 1e20", true},
+	{"100000000000000000000 * 0.5", 5e19},
+	{"let f = fn(n) { if (n < 2) { return 1; } return n * f(n - 1); }; f(25)",
+		bigInt("15511210043330985984000000")},
+	{"{100000000000000000000: 1}[100000000000000000000]", int64(1)},
+	{`int("123456789012345678901234567890")`, bigInt("123456789012345678901234567890")},
+	{"int(1e20)", bigInt("100000000000000000000")},
+	{`type(100000000000000000000)`, "INTEGER"},
+}
+
+func bigInt(s string) *object.BigInt {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("bad big integer " + s)
+	}
+	return &object.BigInt{Value: v}
+}
+
+func TestVMBigIntegers(t *testing.T) {
+	runVMTests(t, bigIntegerTests)
+}
+
+// loopTests are shared with the evaluator, which must agree
+var loopTests = []vmTestCase{
+	{"let s = 0; for (let i = 0; i < 5; i = i + 1) { s = s + i; } s", int64(10)},
+	{"let s = 0; for (let i = 0; i < 10; i = i + 1) { if (i == 3) { break; } s = s + i; } s", int64(3)},
+	{"let s = 0; for (let i = 0; i < 6; i = i + 1) { if (i % 2 == 0) { continue; } s = s + i; } s", int64(9)},
+	{"let i = 0; for (;;) { i = i + 1; if (i == 4) { break; } } i", int64(4)},
+	{"let i = 0; for (; i < 3;) { i = i + 1; } i", int64(3)},
+	{"let i = 0; while (true) { i = i + 1; if (i < 5) { continue; } break; } i", int64(5)},
+	{"let s = 0; for x in [1, 2, 3] { s = s + x; } s", int64(6)},
+	{"let s = 0; for i, x in [5, 6, 7] { s = s + i * x; } s", int64(20)},
+	{`let s = ""; for k in {"a": 1, "b": 2} { s = s + k; } s`, "ab"},
+	{`let n = 0; for k, v in {"a": 1, "b": 20} { if (k == "b") { n = n + v; } } n`, int64(20)},
+	{"let n = 0; for x in [] { n = n + 1; } n", int64(0)},
+	{"let n = 0; for x in [1, 2, 3] { if (x == 2) { continue; } n = n + x; } n", int64(4)},
+	// Nested loops: break and continue only affect the innermost one
+	{`
+	let pairs = 0;
+	for (let i = 0; i < 4; i = i + 1) {
+		for j in [0, 1, 2, 3] {
+			if (j > i) { break; }
+			if (j == 1) { continue; }
+			pairs = pairs + 1;
+		}
+	}
+	pairs`, int64(7)},
+	{`
+	let s = 0;
+	for a in [1, 2] {
+		for b in [10, 20] {
+			s = s + a * b;
+		}
+	}
+	s`, int64(90)},
+	// Loops inside functions use locals, and return leaves every loop
+	{`
+	let find = fn(xs, want) {
+		for i, x in xs {
+			for (let j = 0; j < 3; j = j + 1) {
+				if (x == want) { return i; }
+			}
+		}
+		return -1;
+	};
+	find([4, 5, 6], 6) * 10 + find([], 1)`, int64(19)},
+	{`
+	let sum = fn(n) {
+		let s = 0;
+		for (let i = 1; i <= n; i = i + 1) { s = s + i; }
+		return s;
+	};
+	sum(100)`, int64(5050)},
+	// An array is read as the loop goes; a hash is copied when it starts
+	{"let a = [1]; let n = 0; for x in a { n = n + 1; if (n < 3) { push(a, x); } } n", int64(3)},
+	{`let m = {"a": 1}; let n = 0; for k in m { m[k + "x"] = 1; n = n + 1; } n`, int64(1)},
+	{"let x = 9; for x in [1, 2] {} x", int64(2)},
+}
+
+func TestVMLoops(t *testing.T) {
+	runVMTests(t, loopTests)
+}
+
+// ifTests are shared with the evaluator, which must agree
+var ifTests = []vmTestCase{
+	{"if (true) { 10 }", int64(10)},
+	{"if (false) { 10 }", nil},
+	{"if (1 > 2) { 10 } else { 20 }", int64(20)},
+	{"let x = if (true) { 1; 2 } else { 3 }; x", int64(2)},
+	{"let x = if (true) { let y = 1; } else { 3 }; x", nil},
+	{"let x = if (true) {} else { 3 }; x", nil},
+	{"1 + if (false) { 1 } else { 2 } * 10", int64(21)},
+	{"if (true) { if (false) { 1 } else { 2 } }", int64(2)},
+	{"if (true) { false || 1 > 0 }", true},
+	{"let x = 5; if (x < 0) { -1 } else if (x == 0) { 0 } else { 1 }", int64(1)},
+	{"let x = 0; if (x < 0) { -1 } else if (x == 0) { 0 } else { 1 }", int64(0)},
+	{"let x = -3; if (x < 0) { -1 } else if (x == 0) { 0 } else { 1 }", int64(-1)},
+	{"let x = 7; if (x == 1) { 1 } else if (x == 2) { 2 }", nil},
+	{`
+	let name = fn(n) {
+		if (n == 1) { "one" }
+		else if (n == 2) { "two" }
+		else if (n == 3) { "three" }
+		else { "many" }
+	};
+	name(1) + name(2) + name(3) + name(4)`, "onetwothreemany"},
+	// A trailing expression is a function's result
+	{"let f = fn() { 1; 2 }; f()", int64(2)},
+	{"let f = fn() { let x = 1; }; f()", nil},
+	{"let f = fn(x) { if (x) { return 1; } }; f(false)", nil},
+	{"let f = fn() { for (;;) { break; } }; f()", nil},
+	{"let fact = fn(n) { if (n <= 1) { 1 } else { n * fact(n - 1) } }; fact(5)", int64(120)},
+	{"let n = 0; for x in [1, 2, 3] { n = n + if (x == 2) { 10 } else { x }; } n", int64(14)},
+	{"let n = 0; while (true) { n = n + 1; if (n == 3) { break; } else { n } } n", int64(3)},
+}
+
+func TestVMIfExpressions(t *testing.T) {
+	runVMTests(t, ifTests)
+}
+
+// assignmentTests are shared with the evaluator, which must agree
+var assignmentTests = []vmTestCase{
+	{"let x = 5; x += 3; x", int64(8)},
+	{"let x = 5; x -= 3; x", int64(2)},
+	{"let x = 5; x *= 3; x", int64(15)},
+	{"let x = 17; x /= 5; x", int64(3)},
+	{"let x = 17; x %= 5; x", int64(2)},
+	{"let x = 2.5; x *= 2; x", 5.0},
+	{`let s = "a"; s += "b"; s`, "ab"},
+	{"let x = 1; x++; x++; x--; ++x; --x; ++x; x", int64(3)},
+	{"let s = 0; for (let i = 0; i < 4; i++) { s += i; } s", int64(6)},
+	{"let f = fn() { let n = 1; n += 2; n++; n }; f()", int64(4)},
+	{"let make = fn() { let n = 0; fn() { n++; n } }; let c = make(); c(); c()", int64(2)},
+	// Closures share the variables they capture with the enclosing
+	// function and with each other
+	{"let f = fn() { let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n }; f()", int64(2)},
+	{`
+	let make = fn() {
+		let n = 0;
+		[fn() { n += 10 }, fn() { n++ }, fn() { n }]
+	};
+	let fs = make();
+	fs[0](); fs[1](); fs[0]();
+	fs[2]()`, int64(21)},
+	{"let f = fn(x) { let set = fn() { x = 5 }; set(); x }; f(1)", int64(5)},
+	{"let f = fn() { let n = 1; let g = fn() { fn() { n *= 3 } }; g()(); g()(); n }; f()", int64(9)},
+	{"let f = fn() { let n = 0; let get = fn() { n }; n = 7; get() }; f()", int64(7)},
+	{"let a = [1, 2]; a[1] += 10; a[0]--; a", []interface{}{0, 12}},
+	{"let a = [[1]]; a[0][0] -= 3; a", []interface{}{[]interface{}{-2}}},
+	{`let m = {"n": 1}; m.n += 1; m.n++; m["n"]`, int64(3)},
+	{`let m = {}; m.name = "x"; m["name"]`, "x"},
+	{`let m = {"a": {"b": 1}}; m.a.b = 5; ++m.a.b; m.a.b`, int64(6)},
+	{`{"a": 1}.b`, nil},
+	{`let m = {"f": fn(x) { x * 2 }}; m.f(4)`, int64(8)},
+	// The target's subexpressions are evaluated once
+	{`
+	let calls = 0;
+	let a = [10];
+	let idx = fn() { calls += 1; 0 };
+	a[idx()] += 5;
+	a[idx()]++;
+	a[0] * 100 + calls`, int64(1602)},
+	{`
+	let calls = 0;
+	let m = {"n": 0};
+	let get = fn() { calls += 1; m };
+	get().n += 5;
+	get().n--;
+	m.n * 100 + calls`, int64(402)},
+	// The target is evaluated before the value
+	{`
+	let log = [];
+	let a = [0];
+	let key = fn() { push(log, "key"); 0 };
+	let val = fn() { push(log, "val"); 1 };
+	a[key()] += val();
+	log`, []interface{}{"key", "val"}},
+}
+
+func TestVMAssignments(t *testing.T) {
+	runVMTests(t, assignmentTests)
+}
+
+func TestVMRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + "a"`, "type mismatch: INTEGER + STRING"},
+		{`"a" - "b"`, "unknown operator: STRING - STRING"},
+		{`"a" < "b"`, "unknown operator: STRING < STRING"},
+		{`1 < "a"`, "type mismatch: INTEGER < STRING"},
+		{"[1] >= [2]", "unknown operator: ARRAY >= ARRAY"},
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "modulo by zero"},
+		{"1.5 / 0", "division by zero"},
+		{"1 % 0.0", "modulo by zero"},
+		{`1.5 + "a"`, "type mismatch: FLOAT + STRING"},
+		{"{1.5: 1}", "unusable as hash key: FLOAT"},
+		{`int("x")`, `cannot convert "x" to INTEGER`},
+		{`int(float("inf"))`, "cannot convert Inf to INTEGER"},
+		{"round(true)", "argument to `round` must be a number, got BOOLEAN"},
+		{`-"a"`, "unsupported type for negation: STRING"},
+		{`"a" <= "b"`, "unknown operator: STRING <= STRING"},
+		{"[1, 2][2]", "array index 2 out of range (length 2)"},
+		{"let i = 0 - 1; [1, 2][i]", "negative array index -1"},
+		{`[1]["0"]`, "array index must be INTEGER, got STRING"},
+		{"1[0]", "index operator not supported: INTEGER"},
+		{"let x = 1; x[0] = 2", "index assignment not supported: INTEGER"},
+		{"pop([])", "pop from empty array"},
+		{"{[1]: 2}", "unusable as hash key: ARRAY"},
+		{`{}[{}]`, "unusable as hash key: HASH"},
+		{`let m = {}; m[[]] = 1`, "unusable as hash key: ARRAY"},
+		{"for x in 5 {}", "cannot iterate over INTEGER"},
+		{"let m = {}; m.x += 1", "type mismatch: NULL + INTEGER"},
+		{"let a = [1]; a.x = 1", "array index must be INTEGER, got STRING"},
+		{"let x = true; x++", "type mismatch: BOOLEAN + INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+
+		err := vm.New(comp.Bytecode()).Run()
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%q: wrong error. want %q, got %q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func concatInstructions(parts ...[]byte) bytecode.Instructions {
+	out := bytecode.Instructions{}
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
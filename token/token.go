@@ -1,10 +1,56 @@
 package token
 
+import (
+    "fmt"
+    "sort"
+)
+
 type TokenType string
 
 type Token struct {
     Type    TokenType
     Literal string
+    Span    Span
+}
+
+// Position is a location in the source. Line and Column start at 1;
+// Column counts bytes, so a multi-byte character advances it by more
+// than one.
+type Position struct {
+    Filename string
+    Offset   int // byte offset, starting at 0
+    Line     int
+    Column   int
+}
+
+// IsValid reports whether the position has been set
+func (p Position) IsValid() bool {
+    return p.Line > 0
+}
+
+// String formats the position as file:line:column, omitting the file
+// name when there is none
+func (p Position) String() string {
+    if !p.IsValid() {
+        if p.Filename != "" {
+            return p.Filename
+        }
+        return "-"
+    }
+    if p.Filename != "" {
+        return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+    }
+    return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Span is the half-open source range [Start, End)
+type Span struct {
+    Start Position
+    End   Position
+}
+
+func (s Span) String() string {
+    return s.Start.String()
 }
 
 // Token types
@@ -12,9 +58,15 @@ const (
     ILLEGAL = "ILLEGAL"
     EOF     = "EOF"
 
+    // Comments are only produced when the lexer is asked to keep them,
+    // except doc comments, which the parser always sees
+    COMMENT     = "COMMENT"     // // line or /* block */
+    DOC_COMMENT = "DOC_COMMENT" // /// documents the next declaration
+
     // Identifiers + literals
     IDENT  = "IDENT"  // add, foobar, x, y
-    INT    = "INT"    // 1234
+    INT    = "INT"    // 1234, 0xff, 1_000
+    FLOAT  = "FLOAT"  // 3.14, 1e-9
     STRING = "STRING" // "hello world"
 
     // Operators
@@ -22,23 +74,42 @@ const (
     MINUS    = "-"
     ASTERISK = "*"
     SLASH    = "/"
+    PERCENT  = "%"
+    BANG     = "!"
 
     // Assignment
-    ASSIGN = "="
+    ASSIGN          = "="
+    PLUS_ASSIGN     = "+="
+    MINUS_ASSIGN    = "-="
+    ASTERISK_ASSIGN = "*="
+    SLASH_ASSIGN    = "/="
+    PERCENT_ASSIGN  = "%="
+    INC             = "++"
+    DEC             = "--"
 
     // Comparison
     EQ     = "=="
     NOT_EQ = "!="
     LT     = "<"
     GT     = ">"
+    LT_EQ  = "<="
+    GT_EQ  = ">="
+
+    // Logical
+    AND = "&&"
+    OR  = "||"
 
     // Delimiters
     LPAREN    = "("
     RPAREN    = ")"
     LBRACE    = "{"
     RBRACE    = "}"
+    LBRACKET  = "["
+    RBRACKET  = "]"
     SEMICOLON = ";"
+    COLON     = ":"
     COMMA     = ","
+    DOT       = "."
 
     // Keywords
     LET      = "LET"
@@ -49,19 +120,35 @@ const (
     ELSE     = "ELSE"
     WHILE    = "WHILE"
     FOR      = "FOR"
+    IN       = "IN"
+    BREAK    = "BREAK"
+    CONTINUE = "CONTINUE"
     RETURN   = "RETURN"
 )
 
 var keywords = map[string]TokenType{
-    "let":    LET,
-    "fn":     FUNCTION,
-    "true":   TRUE,
-    "false":  FALSE,
-    "if":     IF,
-    "else":   ELSE,
-    "while":  WHILE,
-    "for":    FOR,
-    "return": RETURN,
+    "let":      LET,
+    "fn":       FUNCTION,
+    "true":     TRUE,
+    "false":    FALSE,
+    "if":       IF,
+    "else":     ELSE,
+    "while":    WHILE,
+    "for":      FOR,
+    "in":       IN,
+    "break":    BREAK,
+    "continue": CONTINUE,
+    "return":   RETURN,
+}
+
+// Keywords returns the reserved words of the language in sorted order
+func Keywords() []string {
+    words := make([]string, 0, len(keywords))
+    for word := range keywords {
+        words = append(words, word)
+    }
+    sort.Strings(words)
+    return words
 }
 
 // LookupIdent checks if an identifier is a keyword
@@ -0,0 +1,23 @@
+package vm
+
+import (
+	"github.com/RavenStorm-bit/toy-compiler/bytecode"
+	"github.com/RavenStorm-bit/toy-compiler/object"
+)
+
+// Frame holds the execution state of a single function call
+type Frame struct {
+	cl          *object.Closure
+	ip          int // index of the next instruction to execute
+	basePointer int // stack pointer before the call's locals were reserved
+}
+
+// NewFrame creates a frame for cl whose locals start at basePointer
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: 0, basePointer: basePointer}
+}
+
+// Instructions returns the instructions of the frame's function
+func (f *Frame) Instructions() bytecode.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -1,74 +1,525 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"strings"
+
 	"github.com/RavenStorm-bit/toy-compiler/bytecode"
+	"github.com/RavenStorm-bit/toy-compiler/object"
+	"github.com/RavenStorm-bit/toy-compiler/stdlib"
 )
 
 const StackSize = 2048
 const GlobalsSize = 65536
+const MaxFrames = 1024
 
 // VM is the virtual machine that executes bytecode
 type VM struct {
-	constants    []interface{}
-	instructions bytecode.Instructions
-	stack        []interface{}
-	sp           int // stack pointer, points to next free slot
-	globals      []interface{}
+	constants []object.Object
+	stack     []object.Object
+	sp        int // stack pointer, points to next free slot
+	globals   []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	err    error         // set when the bytecode failed verification
+	result object.Object // value of a top-level return, if any
+	last   object.Object // last value discarded by a top-level OpPop
+
+	trace io.Writer // receives one line per executed instruction, if set
 }
 
-// New creates a new VM instance
-func New(bytecode *bytecode.Bytecode) *VM {
-	return &VM{
-		constants:    bytecode.Constants,
-		instructions: bytecode.Instructions,
-		stack:        make([]interface{}, StackSize),
-		sp:           0,
-		globals:      make([]interface{}, GlobalsSize),
+// New creates a new VM instance. The bytecode is verified first; if it is
+// malformed the VM refuses to run it and Run returns the verifier's error.
+func New(bc *bytecode.Bytecode) *VM {
+	return NewWithGlobals(bc, make([]object.Object, GlobalsSize))
+}
+
+// NewWithGlobals creates a VM that reads and writes globals instead of a
+// fresh store, so bytecode compiled with compiler.NewWithState can use
+// the globals set by an earlier run
+func NewWithGlobals(bc *bytecode.Bytecode, globals []object.Object) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bc.Instructions, Positions: bc.Positions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	vm := &VM{
+		constants:   bc.Constants,
+		stack:       make([]object.Object, StackSize),
+		sp:          0,
+		globals:     globals,
+		frames:      frames,
+		framesIndex: 1,
 	}
+
+	if err := bytecode.Verify(bc); err != nil {
+		vm.err = err
+	}
+
+	return vm
 }
 
 // LastPoppedStackElem returns the last popped element
-func (vm *VM) LastPoppedStackElem() interface{} {
+func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
-// Run executes the bytecode
+// Result returns the value the program produced: the operand of a
+// top-level return if one ran, otherwise the value of the last top-level
+// statement. The compiler ends a program whose last statement is not an
+// expression by popping null, so that statement results in null too.
+func (vm *VM) Result() object.Object {
+	if vm.result != nil {
+		return vm.result
+	}
+	if vm.last != nil {
+		return vm.last
+	}
+	return object.NULL
+}
+
+// SetTrace makes Run write each instruction it executes to w, together
+// with the stack it operates on. A nil w turns tracing off.
+func (vm *VM) SetTrace(w io.Writer) {
+	vm.trace = w
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow")
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// Run executes the bytecode. A runtime error is prefixed with the source
+// position of the instruction that failed, when the bytecode records it.
 func (vm *VM) Run() error {
-	ip := 0 // instruction pointer
+	if vm.err != nil {
+		return vm.err
+	}
+	if err := vm.run(); err != nil {
+		return vm.errorAt(err)
+	}
+	return nil
+}
 
-	for ip < len(vm.instructions) {
-		ip++ // advance for next iteration
+// errorAt prefixes err with the position of the current frame's last
+// instruction, which is the one that failed
+func (vm *VM) errorAt(err error) error {
+	frame := vm.currentFrame()
+	if pos, ok := frame.cl.Fn.Positions.Lookup(frame.ip - 1); ok {
+		return fmt.Errorf("%s: %w", pos, err)
+	}
+	return err
+}
 
-		op := bytecode.Opcode(vm.instructions[ip-1])
+func (vm *VM) run() error {
+	for {
+		frame := vm.currentFrame()
+		ins := frame.Instructions()
+		if frame.ip >= len(ins) {
+			return nil
+		}
+
+		ip := frame.ip
+		op := bytecode.Opcode(ins[ip])
+		if vm.trace != nil {
+			vm.traceInstruction(ins, ip)
+		}
+		frame.ip++ // advance past the opcode; operands are consumed below
 
 		switch op {
 		case bytecode.OpConstant:
-			constIndex := int(vm.instructions[ip])<<8 | int(vm.instructions[ip+1])
-			ip += 2
+			constIndex := int(bytecode.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
 				return err
 			}
 
-		case bytecode.OpAdd, bytecode.OpSub, bytecode.OpMul, bytecode.OpDiv:
+		case bytecode.OpAdd, bytecode.OpSub, bytecode.OpMul, bytecode.OpDiv, bytecode.OpMod:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
 			}
 
+		case bytecode.OpEqual, bytecode.OpNotEqual, bytecode.OpGreaterThan, bytecode.OpLessThan,
+			bytecode.OpGreaterEqual, bytecode.OpLessEqual:
+			err := vm.executeComparison(op)
+			if err != nil {
+				return err
+			}
+
+		case bytecode.OpMinus:
+			if err := vm.executeMinusOperator(); err != nil {
+				return err
+			}
+
+		case bytecode.OpBang:
+			operand := vm.pop()
+			if err := vm.push(object.NativeBool(!isTruthy(operand))); err != nil {
+				return err
+			}
+
+		case bytecode.OpArray:
+			numElements := int(bytecode.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(&object.Array{Elements: elements}); err != nil {
+				return err
+			}
+
+		case bytecode.OpHash:
+			numElements := int(bytecode.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+
+		case bytecode.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.executeIndexExpression(left, index); err != nil {
+				return err
+			}
+
+		case bytecode.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.executeSetIndex(left, index, value); err != nil {
+				return err
+			}
+
+		case bytecode.OpDup2:
+			a, b := vm.stack[vm.sp-2], vm.stack[vm.sp-1]
+			if err := vm.push(a); err != nil {
+				return err
+			}
+			if err := vm.push(b); err != nil {
+				return err
+			}
+
+		case bytecode.OpIter:
+			iterator, err := object.NewIterator(vm.pop())
+			if err != nil {
+				return err
+			}
+			if err := vm.push(iterator); err != nil {
+				return err
+			}
+
+		case bytecode.OpIterNext:
+			pos := int(bytecode.ReadUint16(ins[ip+1:]))
+			numVars := int(bytecode.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+
+			iterator, ok := vm.pop().(*object.Iterator)
+			if !ok {
+				return fmt.Errorf("OpIterNext on a value that is not an iterator")
+			}
+			vars, ok := iterator.Next(numVars)
+			if !ok {
+				frame.ip = pos
+				break
+			}
+			for _, v := range vars {
+				if err := vm.push(v); err != nil {
+					return err
+				}
+			}
+
+		case bytecode.OpTrue:
+			if err := vm.push(object.TRUE); err != nil {
+				return err
+			}
+
+		case bytecode.OpFalse:
+			if err := vm.push(object.FALSE); err != nil {
+				return err
+			}
+
+		case bytecode.OpNull:
+			if err := vm.push(object.NULL); err != nil {
+				return err
+			}
+
 		case bytecode.OpPop:
-			vm.pop()
+			popped := vm.pop()
+			if vm.framesIndex == 1 {
+				vm.last = popped
+			}
+
+		case bytecode.OpJump:
+			pos := int(bytecode.ReadUint16(ins[ip+1:]))
+			frame.ip = pos
+
+		case bytecode.OpJumpNotTrue:
+			pos := int(bytecode.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				frame.ip = pos
+			}
+
+		case bytecode.OpSetGlobal:
+			globalIndex := bytecode.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.globals[globalIndex] = vm.pop()
+
+		case bytecode.OpGetGlobal:
+			globalIndex := bytecode.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			// A global whose let has not run yet reads as null
+			global := vm.globals[globalIndex]
+			if global == nil {
+				global = object.NULL
+			}
+			if err := vm.push(global); err != nil {
+				return err
+			}
+
+		case bytecode.OpSetLocal:
+			localIndex := bytecode.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			store(&vm.stack[frame.basePointer+int(localIndex)], vm.pop())
+
+		case bytecode.OpGetLocal:
+			localIndex := bytecode.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			if err := vm.push(load(vm.stack[frame.basePointer+int(localIndex)])); err != nil {
+				return err
+			}
+
+		case bytecode.OpCaptureLocal:
+			localIndex := bytecode.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			if err := vm.push(capture(&vm.stack[frame.basePointer+int(localIndex)])); err != nil {
+				return err
+			}
+
+		case bytecode.OpGetBuiltin:
+			builtinIndex := bytecode.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			if int(builtinIndex) >= len(stdlib.Builtins) {
+				return fmt.Errorf("undefined builtin %d", builtinIndex)
+			}
+			if err := vm.push(stdlib.Builtins[builtinIndex]); err != nil {
+				return err
+			}
+
+		case bytecode.OpCall:
+			numArgs := bytecode.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			if err := vm.callFunction(int(numArgs)); err != nil {
+				return err
+			}
+
+		case bytecode.OpClosure:
+			constIndex := bytecode.ReadUint16(ins[ip+1:])
+			numFree := bytecode.ReadUint8(ins[ip+3:])
+			frame.ip += 3
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+
+		case bytecode.OpGetFree:
+			freeIndex := bytecode.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			if err := vm.push(load(frame.cl.Free[freeIndex])); err != nil {
+				return err
+			}
+
+		case bytecode.OpSetFree:
+			freeIndex := bytecode.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			store(&frame.cl.Free[freeIndex], vm.pop())
+
+		case bytecode.OpCaptureFree:
+			freeIndex := bytecode.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			if err := vm.push(capture(&frame.cl.Free[freeIndex])); err != nil {
+				return err
+			}
+
+		case bytecode.OpCurrentClosure:
+			if err := vm.push(frame.cl); err != nil {
+				return err
+			}
+
+		case bytecode.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.framesIndex == 1 {
+				// A return at the top level ends the program
+				vm.result = returnValue
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+
+		case bytecode.OpReturn:
+			if vm.framesIndex == 1 {
+				vm.result = object.NULL
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(object.NULL); err != nil {
+				return err
+			}
 
 		default:
 			return fmt.Errorf("unknown opcode: %d", op)
 		}
 	}
+}
+
+// traceInstruction writes the instruction at ip, indented by call depth,
+// followed by the current frame's part of the stack
+func (vm *VM) traceInstruction(ins bytecode.Instructions, ip int) {
+	base := vm.currentFrame().basePointer
+	values := make([]string, 0, vm.sp-base)
+	for _, o := range vm.stack[base:vm.sp] {
+		values = append(values, o.Inspect())
+	}
+
+	indent := strings.Repeat("  ", vm.framesIndex-1)
+	line := fmt.Sprintf("%s%04d %s", indent, ip, bytecode.FormatInstruction(ins[ip:]))
+	fmt.Fprintf(vm.trace, "%-32s [%s]\n", line, strings.Join(values, ", "))
+}
+
+func (vm *VM) callFunction(numArgs int) error {
+	switch callee := vm.stack[vm.sp-1-numArgs].(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	fn := cl.Fn
+	if numArgs != fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			fn.NumParameters, numArgs)
+	}
+
+	// Checked before the frame is pushed, so the error is reported at
+	// the call
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if frame.basePointer+fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+	vm.sp = frame.basePointer + fn.NumLocals
+
+	// Locals other than the parameters start out null rather than holding
+	// whatever an earlier call left in their slots
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = object.NULL
+	}
 
 	return nil
 }
 
-func (vm *VM) push(o interface{}) error {
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if err, ok := result.(*object.Error); ok {
+		return errors.New(err.Message)
+	}
+	return vm.push(result)
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	fn, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %s", constant.Type())
+	}
+
+	free := make([]object.Object, numFree)
+	copy(free, vm.stack[vm.sp-numFree:vm.sp])
+	vm.sp = vm.sp - numFree
+
+	return vm.push(&object.Closure{Fn: fn, Free: free})
+}
+
+// load returns the value of a variable slot, reading through the cell if
+// a closure has captured the variable
+func load(slot object.Object) object.Object {
+	if cell, ok := slot.(*object.Cell); ok {
+		return cell.Value
+	}
+	return slot
+}
+
+// store assigns a variable slot, writing into its cell if it has one so
+// every closure sharing the variable sees the value
+func store(slot *object.Object, value object.Object) {
+	if cell, ok := (*slot).(*object.Cell); ok {
+		cell.Value = value
+		return
+	}
+	*slot = value
+}
+
+// capture returns the cell holding a variable slot, first moving the
+// variable into a new cell if no closure has captured it yet
+func capture(slot *object.Object) *object.Cell {
+	cell, ok := (*slot).(*object.Cell)
+	if !ok {
+		cell = &object.Cell{Value: *slot}
+		*slot = cell
+	}
+	return cell
+}
+
+func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
 		return fmt.Errorf("stack overflow")
 	}
@@ -78,27 +529,86 @@ func (vm *VM) push(o interface{}) error {
 	return nil
 }
 
-func (vm *VM) pop() interface{} {
+func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
 	vm.sp--
 	return o
 }
 
+// isTruthy reports whether a value counts as true in a condition: null
+// and false are falsy, everything else is truthy
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
 func (vm *VM) executeBinaryOperation(op bytecode.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	leftValue, ok := left.(int64)
-	if !ok {
-		return fmt.Errorf("expected integer, got %T", left)
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		// One of them is a float, so the integer is promoted
+		return vm.executeBinaryFloatOperation(op, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return vm.executeBinaryStringOperation(op, left, right)
+	default:
+		return operatorError(op, left, right)
+	}
+}
+
+// operators maps the binary opcodes to the operators they were compiled
+// from. The arithmetic ones are those understood by
+// object.IntegerArithmetic.
+var operators = map[bytecode.Opcode]string{
+	bytecode.OpAdd:          "+",
+	bytecode.OpSub:          "-",
+	bytecode.OpMul:          "*",
+	bytecode.OpDiv:          "/",
+	bytecode.OpMod:          "%",
+	bytecode.OpEqual:        "==",
+	bytecode.OpNotEqual:     "!=",
+	bytecode.OpGreaterThan:  ">",
+	bytecode.OpLessThan:     "<",
+	bytecode.OpGreaterEqual: ">=",
+	bytecode.OpLessEqual:    "<=",
+}
+
+// operatorError reports a binary operator applied to operands it does not
+// support, in the evaluator's words
+func operatorError(op bytecode.Opcode, left, right object.Object) error {
+	if left.Type() != right.Type() {
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[op], right.Type())
 	}
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+}
 
-	rightValue, ok := right.(int64)
+func (vm *VM) executeBinaryIntegerOperation(op bytecode.Opcode, left, right object.Object) error {
+	operator, ok := operators[op]
 	if !ok {
-		return fmt.Errorf("expected integer, got %T", right)
+		return fmt.Errorf("unknown binary operator: %d", op)
+	}
+
+	result, err := object.IntegerArithmetic(operator, left, right)
+	if err != nil {
+		return err
 	}
+	return vm.push(result)
+}
+
+func (vm *VM) executeBinaryFloatOperation(op bytecode.Opcode, left, right object.Object) error {
+	leftValue, _ := object.AsFloat(left)
+	rightValue, _ := object.AsFloat(right)
 
-	var result int64
+	var result float64
 
 	switch op {
 	case bytecode.OpAdd:
@@ -112,9 +622,148 @@ func (vm *VM) executeBinaryOperation(op bytecode.Opcode) error {
 			return fmt.Errorf("division by zero")
 		}
 		result = leftValue / rightValue
+	case bytecode.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("modulo by zero")
+		}
+		result = math.Mod(leftValue, rightValue)
 	default:
 		return fmt.Errorf("unknown binary operator: %d", op)
 	}
 
-	return vm.push(result)
+	return vm.push(&object.Float{Value: result})
+}
+
+func isNumber(obj object.Object) bool {
+	_, ok := object.AsFloat(obj)
+	return ok
+}
+
+func (vm *VM) executeBinaryStringOperation(op bytecode.Opcode, left, right object.Object) error {
+	if op != bytecode.OpAdd {
+		return operatorError(op, left, right)
+	}
+
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	return vm.push(&object.String{Value: leftValue + rightValue})
+}
+
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	switch operand := operand.(type) {
+	case *object.Integer, *object.BigInt:
+		return vm.push(object.NegateInteger(operand))
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
+		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	}
+}
+
+// buildHash makes a hash from the key/value pairs in stack[start:end]
+func (vm *VM) buildHash(start, end int) (object.Object, error) {
+	hash := object.NewHash()
+
+	for i := start; i < end; i += 2 {
+		key, ok := vm.stack[i].(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", vm.stack[i].Type())
+		}
+		hash.Set(key, vm.stack[i+1])
+	}
+
+	return hash, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, err := object.ArrayIndex(index, len(left.Elements))
+		if err != nil {
+			return err
+		}
+		return vm.push(left.Elements[i])
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		if value, ok := left.Get(key); ok {
+			return vm.push(value)
+		}
+		return vm.push(object.NULL)
+
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, err := object.ArrayIndex(index, len(left.Elements))
+		if err != nil {
+			return err
+		}
+		left.Elements[i] = value
+		return nil
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.Set(key, value)
+		return nil
+
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeComparison(op bytecode.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	switch op {
+	case bytecode.OpEqual:
+		return vm.push(object.NativeBool(object.Equal(left, right)))
+	case bytecode.OpNotEqual:
+		return vm.push(object.NativeBool(!object.Equal(left, right)))
+	}
+
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		cmp := object.CompareIntegers(left, right)
+		switch op {
+		case bytecode.OpGreaterThan:
+			return vm.push(object.NativeBool(cmp > 0))
+		case bytecode.OpLessThan:
+			return vm.push(object.NativeBool(cmp < 0))
+		case bytecode.OpGreaterEqual:
+			return vm.push(object.NativeBool(cmp >= 0))
+		case bytecode.OpLessEqual:
+			return vm.push(object.NativeBool(cmp <= 0))
+		}
+	}
+
+	leftFloat, leftIsNumber := object.AsFloat(left)
+	rightFloat, rightIsNumber := object.AsFloat(right)
+	if leftIsNumber && rightIsNumber {
+		switch op {
+		case bytecode.OpGreaterThan:
+			return vm.push(object.NativeBool(leftFloat > rightFloat))
+		case bytecode.OpLessThan:
+			return vm.push(object.NativeBool(leftFloat < rightFloat))
+		case bytecode.OpGreaterEqual:
+			return vm.push(object.NativeBool(leftFloat >= rightFloat))
+		case bytecode.OpLessEqual:
+			return vm.push(object.NativeBool(leftFloat <= rightFloat))
+		}
+	}
+
+	return operatorError(op, left, right)
 }